@@ -0,0 +1,169 @@
+package main
+
+// api.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiRequest calls the deadmanssnitch.com api, payload is marshalled to json when not nil
+func apiRequest(method string, path string, payload interface{}) (int, []byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	uri := apiurl + path
+
+	req, err := http.NewRequest(method, uri, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.SetBasicAuth(apikey, "")
+	if payload != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	if verbose {
+		fmt.Println("   API:", method, uri, string(body))
+		if dump, err := httputil.DumpRequestOut(req, false); err == nil {
+			fmt.Print(redactSecrets(string(dump)))
+		}
+	}
+
+	client := &http.Client{}
+	client.Timeout = time.Second * 15
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errorresponse dmsResp
+		json.Unmarshal(data, &errorresponse)
+		if errorresponse.Error != "" {
+			return resp.StatusCode, data, fmt.Errorf("%s: %s", http.StatusText(resp.StatusCode), errorresponse.Error)
+		}
+		return resp.StatusCode, data, fmt.Errorf("%s", http.StatusText(resp.StatusCode))
+	}
+
+	return resp.StatusCode, data, nil
+}
+
+func getSnitch(token string) (oneSnitch, error) {
+	var found oneSnitch
+	_, data, err := apiRequest("GET", "/snitches/"+url.PathEscape(token), nil)
+	if err != nil {
+		return found, err
+	}
+	err = json.Unmarshal(data, &found)
+	return found, err
+}
+
+func listSnitches() ([]oneSnitch, error) {
+	var found []oneSnitch
+	_, data, err := apiRequest("GET", "/snitches", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &found)
+	return found, err
+}
+
+// postSnitch creates a snitch and returns it as created, including its new token
+func postSnitch(newsnitch newSnitch) (oneSnitch, error) {
+	var created oneSnitch
+	_, data, err := apiRequest("POST", "/snitches", newsnitch)
+	if err != nil {
+		return created, err
+	}
+	err = json.Unmarshal(data, &created)
+	return created, err
+}
+
+// findSnitch looks up a snitch by its token or, failing that, its name
+func findSnitch(ref string) (oneSnitch, error) {
+	snitches, err := listSnitches()
+	if err != nil {
+		return oneSnitch{}, err
+	}
+	for _, s := range snitches {
+		if s.Token == ref {
+			return s, nil
+		}
+	}
+	for _, s := range snitches {
+		if strings.EqualFold(s.Name, ref) {
+			return s, nil
+		}
+	}
+	return oneSnitch{}, fmt.Errorf("no snitch with token or name %q", ref)
+}
+
+// showAllProfiles lists the snitches of every profile in the config, using each profile's own key
+func showAllProfiles() {
+	profiles := viper.GetStringMap("profiles")
+	if len(profiles) == 0 {
+		fmt.Println("ERROR: No profiles in config")
+		os.Exit(1)
+	}
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "Profile", "Snitch", "Name", "Status", "Last CheckIn", "Interval", "Alert Type", "Tags")
+
+	for _, name := range names {
+		profile := viper.Sub("profiles." + name)
+		if profile == nil || profile.GetString("apikey") == "" {
+			fmt.Fprintf(w, "%s\t%s\n", name, "ERROR: no apikey")
+			continue
+		}
+		var err error
+		if apikey, err = resolveSecret(profile.GetString("apikey")); err != nil {
+			fmt.Fprintf(w, "%s\t%s\n", name, "ERROR: cannot read apikey: "+err.Error())
+			continue
+		}
+		apiurl = strings.TrimSuffix(viper.GetString("apiurl"), "/")
+		if profile.GetString("apiurl") != "" {
+			apiurl = strings.TrimSuffix(profile.GetString("apiurl"), "/")
+		}
+
+		snitches, err := listSnitches()
+		if err != nil {
+			fmt.Fprintf(w, "%s\t%s\n", name, "ERROR: "+err.Error())
+			continue
+		}
+		for _, s := range snitches {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t[%s]\n", name, s.Token, s.Name, s.Status, s.CheckedInAt.Format("2006-01-02 15:04:05"), s.Interval, s.AlertType, strings.Join(s.Tags, ","))
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+// api_test.go
+
+import (
+	"encoding/json"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f prints
+func captureStdout(t *testing.T, f func()) string {
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	done := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(read)
+		done <- string(data)
+	}()
+	f()
+	os.Stdout = stdout
+	write.Close()
+	return <-done
+}
+
+func TestShowAllProfiles(t *testing.T) {
+	// each account answers only to its own key
+	account := func(key string, name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if user, _, _ := req.BasicAuth(); user != key || req.URL.Path != "/v1/snitches" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode([]oneSnitch{{Token: key + "token", Name: name, Status: "healthy", Interval: "daily"}})
+		}))
+	}
+	work := account("workkey", "work backups")
+	defer work.Close()
+	home := account("homekey", "home backups")
+	defer home.Close()
+
+	defer func(k string, u string) { apikey, apiurl = k, u }(apikey, apiurl)
+	defer viper.Set("apiurl", nil)
+	defer viper.Set("profiles", nil)
+	viper.Set("apiurl", work.URL+"/v1/")
+	viper.Set("profiles", map[string]interface{}{
+		"work":    map[string]interface{}{"apikey": "workkey"},
+		"home":    map[string]interface{}{"apikey": "homekey", "apiurl": home.URL + "/v1"},
+		"wrong":   map[string]interface{}{"apikey": "homekey"},
+		"nothing": map[string]interface{}{"defaultsnitch": "abc"},
+	})
+
+	lines := strings.Split(strings.TrimSpace(captureStdout(t, showAllProfiles)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("show --all-profiles printed %q, want a header and 4 profiles", lines)
+	}
+	for i, want := range [][]string{
+		{"home", "homekeytoken", "home backups"},
+		{"nothing", "ERROR: no apikey"},
+		{"work", "workkeytoken", "work backups"},
+		{"wrong", "ERROR: Unauthorized"},
+	} {
+		for _, field := range want {
+			if !strings.Contains(lines[i+1], field) {
+				t.Errorf("show --all-profiles line %q, want %q", lines[i+1], field)
+			}
+		}
+	}
+}
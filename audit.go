@@ -0,0 +1,257 @@
+package main
+
+// audit.go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"log"
+	"log/syslog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// auditEntry records a change made to a snitch, one json line per change in the audit log
+type auditEntry struct {
+	Time     time.Time  `json:"time"`
+	Action   string     `json:"action"` // create, update, tag, delete, pause or unpause
+	Token    string     `json:"token"`
+	User     string     `json:"user"`
+	SudoUser string     `json:"sudo_user,omitempty"`
+	Host     string     `json:"host"`
+	Profile  string     `json:"profile,omitempty"`
+	Command  string     `json:"command"`
+	Before   *oneSnitch `json:"before,omitempty"`
+	After    *oneSnitch `json:"after,omitempty"`
+	Result   string     `json:"result"` // "ok", or the error from the api
+}
+
+var auditmu sync.Mutex
+
+// auditLogFile returns the audit log path, audit.log from config or audit.jsonl in the state directory, "" when set to "none"
+func auditLogFile() (string, error) {
+	path := viper.GetString("audit.log")
+	if strings.ToLower(path) == "none" {
+		return "", nil
+	}
+	if path != "" {
+		return path, nil
+	}
+	dir, err := stateDir("")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// auditSnitch looks up a snitch to record its state in the audit log, nil when it cannot be read
+func auditSnitch(token string) *oneSnitch {
+	if apikey == "" || token == "" {
+		return nil
+	}
+	found, err := getSnitch(token)
+	if err != nil {
+		return nil
+	}
+	return &found
+}
+
+// recordAudit appends a change to the audit log, and to syslog when audit.syslog is set.
+// Failing to write the audit log never stops the change, which has already been made.
+func recordAudit(action string, token string, before *oneSnitch, after *oneSnitch, err error) {
+	entry := auditEntry{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		Token:    token,
+		SudoUser: os.Getenv("SUDO_USER"),
+		Profile:  viper.GetString("profile"),
+		Command:  auditCommandLine(os.Args),
+		Before:   before,
+		After:    after,
+		Result:   "ok",
+	}
+	if u, uerr := user.Current(); uerr == nil {
+		entry.User = u.Username
+	} else {
+		entry.User = os.Getenv("USER")
+	}
+	entry.Host, _ = os.Hostname()
+	if err != nil {
+		entry.Result = redactSecrets(err.Error())
+	}
+
+	line, jerr := json.Marshal(entry)
+	if jerr != nil {
+		return
+	}
+
+	auditmu.Lock()
+	defer auditmu.Unlock()
+
+	if viper.GetBool("audit.syslog") {
+		if w, serr := syslog.New(syslog.LOG_NOTICE|syslog.LOG_USER, "snitchit"); serr == nil {
+			w.Notice(string(line))
+			w.Close()
+		} else if verbose {
+			fmt.Println("Audit: cannot write to syslog:", serr)
+		}
+	}
+
+	path, perr := auditLogFile()
+	if perr != nil {
+		log.Println("ERROR: Cannot find audit log:", perr)
+		return
+	}
+	if path == "" {
+		return
+	}
+	f, ferr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if ferr != nil {
+		log.Println("ERROR: Cannot open audit log:", ferr)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// auditCommandLine joins the command line for the audit log, hiding the api key and any other secret
+func auditCommandLine(args []string) string {
+	var cleaned []string
+	hide := false
+	for _, arg := range args {
+		switch {
+		case hide:
+			arg = "[REDACTED]"
+			hide = false
+		case arg == "--apikey" || arg == "-apikey":
+			hide = true
+		case strings.HasPrefix(arg, "--apikey=") || strings.HasPrefix(arg, "-apikey="):
+			arg = arg[:strings.Index(arg, "=")+1] + "[REDACTED]"
+		}
+		cleaned = append(cleaned, redactSecrets(arg))
+	}
+	return strings.Join(cleaned, " ")
+}
+
+func auditCommand() {
+	switch strings.ToLower(pflag.Arg(1)) {
+	case "query":
+		auditQuery()
+	default:
+		fmt.Println("ERROR: Invalid audit command", pflag.Arg(1), ". Please choose \"query\"")
+		os.Exit(1)
+	}
+}
+
+// auditQuery prints the audit log entries matching --snitch, --action, --since and --until
+func auditQuery() {
+	var token string
+	if viper.GetString("snitch") != "" {
+		token = resolveSnitch(viper.GetString("snitch"))
+	}
+	actions := make(map[string]bool)
+	for _, action := range strings.Split(strings.ToLower(viper.GetString("action")), ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			actions[action] = true
+		}
+	}
+	since, err := parseAuditTime(viper.GetString("since"))
+	if err != nil {
+		fmt.Println("ERROR: Invalid --since:", err)
+		os.Exit(1)
+	}
+	until, err := parseAuditTime(viper.GetString("until"))
+	if err != nil {
+		fmt.Println("ERROR: Invalid --until:", err)
+		os.Exit(1)
+	}
+
+	path, err := auditLogFile()
+	if err != nil || path == "" {
+		fmt.Println("ERROR: No audit log")
+		os.Exit(1)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("ERROR: Cannot read audit log:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	var found []auditEntry
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if token != "" && entry.Token != token {
+			continue
+		}
+		if len(actions) > 0 && !actions[entry.Action] {
+			continue
+		}
+		if (!since.IsZero() && entry.Time.Before(since)) || (!until.IsZero() && entry.Time.After(until)) {
+			continue
+		}
+		found = append(found, entry)
+		lines = append(lines, scanner.Text())
+	}
+
+	if strings.ToLower(viper.GetString("format")) == "json" {
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "Time", "Action", "Snitch", "Name", "User", "Host", "Result")
+	for _, entry := range found {
+		who := entry.User
+		if entry.SudoUser != "" {
+			who = entry.SudoUser + " as " + entry.User
+		}
+		name := ""
+		if entry.After != nil {
+			name = entry.After.Name
+		} else if entry.Before != nil {
+			name = entry.Before.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Action, entry.Token, name, who, entry.Host, entry.Result)
+	}
+	w.Flush()
+
+	if verbose {
+		fmt.Println(len(found), "entries from", path)
+	}
+}
+
+// parseAuditTime reads a time for --since and --until, either a duration ago such as "24h", a date or an RFC 3339 time
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration such as \"24h\", a date such as \"2006-01-02\" or an RFC 3339 time", value)
+}
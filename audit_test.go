@@ -0,0 +1,112 @@
+package main
+
+// audit_test.go
+
+import (
+	"encoding/json"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditCommandLine(t *testing.T) {
+	secret, err := resolveSecret("exec:echo auditsecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"snitchit", "--show"}, "snitchit --show"},
+		{[]string{"snitchit", "--apikey", "abc123", "--delete", "x"}, "snitchit --apikey [REDACTED] --delete x"},
+		{[]string{"snitchit", "-apikey", "abc123"}, "snitchit -apikey [REDACTED]"},
+		{[]string{"snitchit", "--apikey=abc123", "--show"}, "snitchit --apikey=[REDACTED] --show"},
+		{[]string{"snitchit", "--apikey"}, "snitchit --apikey"},
+		{[]string{"snitchit", "--message", "key " + secret}, "snitchit --message key [REDACTED]"},
+	}
+	for _, test := range tests {
+		if got := auditCommandLine(test.args); got != test.want {
+			t.Errorf("auditCommandLine(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+	defer viper.Set("audit.log", nil)
+	viper.Set("audit.log", path)
+
+	_, cleanup := fakeAPI(t, []oneSnitch{{Token: "old1", Name: "old backups", Interval: "daily"}})
+	defer cleanup()
+	_, checkinsCleanup := fakeCheckIns(t)
+	defer checkinsCleanup()
+	defer func(k string, args []string) { apikey, os.Args = k, args }(apikey, os.Args)
+	apikey = "auditkey"
+	os.Args = []string{"snitchit", "--apikey", apikey, "--create"}
+
+	defer viper.Set("interval", nil)
+	defer viper.Set("alert", nil)
+	viper.Set("interval", "daily")
+	viper.Set("alert", "basic")
+	captureStdout(t, func() {
+		createSnitch(newSnitch{Name: "db backups", Interval: "daily"})
+		unpauseSnitch("old1")
+		// the fake api cannot delete, so this records a failed change
+		deleteSnitch("old1")
+	})
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("audit log = %q, want 3 entries", lines)
+	}
+	var created, unpaused, deleted auditEntry
+	json.Unmarshal([]byte(lines[0]), &created)
+	json.Unmarshal([]byte(lines[1]), &unpaused)
+	json.Unmarshal([]byte(lines[2]), &deleted)
+
+	if created.Action != "create" || created.Token != "new1" || created.Result != "ok" || created.Before != nil || created.After == nil || created.After.Name != "db backups" {
+		t.Errorf("create entry = %s", lines[0])
+	}
+	if created.Command != "snitchit --apikey [REDACTED] --create" || created.Host == "" || created.Time.IsZero() {
+		t.Errorf("create entry = %s, want the command line redacted, the host and time", lines[0])
+	}
+	if unpaused.Action != "unpause" || unpaused.Token != "old1" || unpaused.Result != "ok" || unpaused.Before == nil || unpaused.After == nil || unpaused.After.Name != "old backups" {
+		t.Errorf("unpause entry = %s, want the snitch before and after", lines[1])
+	}
+	if deleted.Action != "delete" || deleted.Token != "old1" || deleted.Result == "ok" || deleted.Before == nil || deleted.Before.Name != "old backups" || deleted.After != nil {
+		t.Errorf("delete entry = %s, want the failure and the snitch before", lines[2])
+	}
+	if strings.Contains(string(data), apikey) {
+		t.Errorf("audit log contains the api key:\n%s", data)
+	}
+
+	// audit query filters by action and prints json lines as they are in the log
+	defer viper.Set("action", nil)
+	defer viper.Set("format", nil)
+	viper.Set("action", "delete")
+	viper.Set("format", "json")
+	if got := strings.TrimSpace(captureStdout(t, auditQuery)); got != lines[2] {
+		t.Errorf("audit query --action delete = %q, want %q", got, lines[2])
+	}
+
+	viper.Set("action", nil)
+	viper.Set("format", nil)
+	shown := strings.Split(strings.TrimSpace(captureStdout(t, auditQuery)), "\n")
+	if len(shown) != 4 || !strings.Contains(shown[1], "db backups") || !strings.Contains(shown[3], "old backups") {
+		t.Errorf("audit query = %q, want a header and every entry", shown)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+// checkin.go
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// maxCheckIns is how many check ins checkin --all sends at once
+const maxCheckIns = 10
+
+// configuredSnitch is an entry of the snitches list of the config and the token it resolves to
+type configuredSnitch struct {
+	Entry string
+	Token string
+}
+
+// configuredSnitches returns the snitches list of the config with aliases resolved, dropping repeats
+func configuredSnitches() []configuredSnitch {
+	var configured []configuredSnitch
+	seen := make(map[string]bool)
+	for _, entry := range viper.GetStringSlice("snitches") {
+		entry = strings.TrimSpace(entry)
+		token := resolveSnitch(entry)
+		if entry == "" || seen[token] {
+			continue
+		}
+		seen[token] = true
+		configured = append(configured, configuredSnitch{Entry: entry, Token: token})
+	}
+	return configured
+}
+
+// checkInCommand is snitchit checkin, which checks in to --snitch, or with --all to every configured snitch
+func checkInCommand() {
+	if !viper.GetBool("all") {
+		if len(snitch) == 0 {
+			fmt.Println("ERROR: No snitch defined")
+			os.Exit(1)
+		}
+		sendSnitch(snitch)
+		return
+	}
+
+	configured := configuredSnitches()
+	if len(configured) == 0 {
+		fmt.Println("ERROR: No snitches in config")
+		os.Exit(1)
+	}
+
+	type checkInResult struct {
+		Result string
+		Took   time.Duration
+		Failed bool
+	}
+	results := make([]checkInResult, len(configured))
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, maxCheckIns)
+	for i, c := range configured {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			if throttled(token, "") {
+				results[i] = checkInResult{Result: "SKIPPED, within --min-gap"}
+				return
+			}
+			started := time.Now()
+			err := deliverCheckIn(token, message, "")
+			results[i] = checkInResult{Result: "OK", Took: time.Since(started)}
+			if err != nil {
+				results[i] = checkInResult{Result: "ERROR: " + err.Error(), Took: time.Since(started), Failed: true}
+			}
+		}(i, c.Token)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Failed {
+			failed++
+		}
+	}
+
+	if !silent {
+		fmt.Println("Message:", message)
+		w := new(tabwriter.Writer)
+		// minwidth, tabwidth, padding, padchar, flags
+		w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "Snitch", "Token", "Took", "Result")
+		for i, c := range configured {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Entry, c.Token, results[i].Took.Round(time.Millisecond), results[i].Result)
+		}
+		w.Flush()
+	}
+
+	if failed > 0 {
+		if !silent {
+			fmt.Printf("ERROR: %d of %d check ins failed\n", failed, len(configured))
+		}
+		os.Exit(1)
+	}
+}
+
+// showConfigured displays the snitches in the snitches list of the config, flagging entries the account does not have
+// and snitches in the account which are not in the list
+func showConfigured() {
+	configured := configuredSnitches()
+	if len(configured) == 0 {
+		fmt.Println("ERROR: No snitches in config")
+		os.Exit(1)
+	}
+
+	snitches, err := listSnitches()
+	if err != nil {
+		fmt.Println("ERROR: Cannot list snitches:", err)
+		os.Exit(1)
+	}
+	account := make(map[string]oneSnitch)
+	for _, s := range snitches {
+		account[s.Token] = s
+	}
+
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "Snitch", "Name", "Status", "Last CheckIn", "Interval", "Alert Type", "Tags")
+
+	listed := make(map[string]bool)
+	missing := 0
+	for _, c := range configured {
+		listed[c.Token] = true
+		s, ok := account[c.Token]
+		if !ok {
+			missing++
+			fmt.Fprintf(w, "%s\t%s\n", c.Entry, "ERROR: not in account")
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t[%s]\n", s.Token, s.Name, s.Status, s.CheckedInAt.Format("2006-01-02 15:04:05"), s.Interval, s.AlertType, strings.Join(s.Tags, ","))
+	}
+	w.Flush()
+
+	var unlisted []oneSnitch
+	for _, s := range snitches {
+		if !listed[s.Token] {
+			unlisted = append(unlisted, s)
+		}
+	}
+	if len(unlisted) > 0 {
+		fmt.Println()
+		fmt.Println("In the account but not in snitches:")
+		for _, s := range unlisted {
+			fmt.Printf("  %s\t%s\n", s.Token, s.Name)
+		}
+	}
+
+	if verbose {
+		fmt.Printf("%d configured, %d not in account, %d not configured\n", len(configured), missing, len(unlisted))
+	}
+}
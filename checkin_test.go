@@ -0,0 +1,48 @@
+package main
+
+// checkin_test.go
+
+import (
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckInAll(t *testing.T) {
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+	defer func(m string) { message = m }(message)
+	message = "all ok"
+	defer viper.Set("all", nil)
+	defer viper.Set("snitches", nil)
+	defer viper.Set("aliases", nil)
+	defer viper.Set("min-gap", nil)
+
+	viper.Set("all", true)
+	viper.Set("snitches", []string{"all1", "db", " ", "all1", "alltok", "allgap"})
+	viper.Set("aliases", map[string]interface{}{"db": "alltok"})
+	viper.Set("min-gap", time.Hour)
+	sendCheckIn("allgap", "earlier", "")
+
+	output := captureStdout(t, checkInCommand)
+
+	// every configured snitch is checked in to once, aliases resolved, skipping those within --min-gap
+	checkins := received()
+	sort.Strings(checkins)
+	if want := []string{"all1 all ok", "allgap earlier", "alltok all ok"}; !equalStrings(checkins, want) {
+		t.Errorf("check ins = %q, want %q", checkins, want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 5 || lines[0] != "Message: all ok" {
+		t.Fatalf("checkin --all printed %q, want the message, a header and 3 snitches", lines)
+	}
+	for i, want := range [][]string{{"all1", "all1", "OK"}, {"db", "alltok", "OK"}, {"allgap", "allgap", "SKIPPED, within --min-gap"}} {
+		fields := strings.FieldsFunc(lines[i+2], func(r rune) bool { return r == '\t' })
+		if len(fields) != 4 || fields[0] != want[0] || fields[1] != want[1] || fields[3] != want[2] {
+			t.Errorf("checkin --all line %q, want %q", lines[i+2], want)
+		}
+	}
+}
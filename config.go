@@ -0,0 +1,155 @@
+package main
+
+// config.go
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// configorigin records which file or profile set each config key
+var configorigin = make(map[string]string)
+
+// configFiles returns the config files to layer, lowest precedence first:
+// /etc/snitchit/config.d/*.yaml, $XDG_CONFIG_HOME/snitchit/config.yaml, .snitchit.yaml in each directory
+// from the repository root or home directory down to the current directory, ./config.yaml, then --config or $SNITCHIT_CONFIG
+func configFiles() []string {
+	var files []string
+
+	system, _ := filepath.Glob("/etc/snitchit/config.d/*.yaml")
+	sort.Strings(system)
+	files = append(files, system...)
+
+	home, _ := os.UserHomeDir()
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	if xdg != "" {
+		files = append(files, filepath.Join(xdg, "snitchit", "config.yaml"))
+	}
+
+	// walk up from the current directory, stopping at the root of the repository or the home directory
+	var local []string
+	if dir, err := os.Getwd(); err == nil {
+		for {
+			local = append([]string{filepath.Join(dir, ".snitchit.yaml")}, local...)
+			if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+				break
+			}
+			if home != "" && dir == filepath.Clean(home) {
+				break
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	// a file another user could have written, such as in a shared directory, could point snitchit anywhere
+	for _, file := range local {
+		if problem := untrustedConfig(file); problem != "" {
+			if !viper.GetBool("silent") {
+				fmt.Println("WARNING: Ignoring", file+",", problem)
+			}
+			continue
+		}
+		files = append(files, file)
+	}
+
+	files = append(files, "config.yaml")
+
+	if pflag.Lookup("config").Changed || os.Getenv("SNITCHIT_CONFIG") != "" {
+		files = append(files, viper.GetString("config"))
+	}
+
+	var found []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		// --config often names a file already found, such as ./config.yaml
+		abs, _ := filepath.Abs(file)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() && !seen[abs] {
+			found = append(found, file)
+			seen[abs] = true
+		}
+	}
+	return found
+}
+
+// untrustedConfig explains why a config file found walking up should not be read, "" when it can be
+func untrustedConfig(file string) string {
+	info, err := os.Lstat(file)
+	if err != nil {
+		// missing files are left out later
+		return ""
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 && int(stat.Uid) != os.Getuid() {
+		return "it is owned by another user"
+	}
+	if dir, err := os.Stat(filepath.Dir(file)); err == nil && dir.Mode().Perm()&0022 != 0 {
+		return "other users can write to its directory"
+	}
+	return ""
+}
+
+// loadConfig merges every config file found in to viper, later files overriding earlier ones
+func loadConfig() error {
+	viper.SetConfigType("yaml")
+
+	if explicit := viper.GetString("config"); (pflag.Lookup("config").Changed || os.Getenv("SNITCHIT_CONFIG") != "") && explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return err
+		}
+	}
+
+	files := configFiles()
+	if len(files) == 0 {
+		return fmt.Errorf("none of /etc/snitchit/config.d/*.yaml, $XDG_CONFIG_HOME/snitchit/config.yaml, .snitchit.yaml, ./config.yaml or --config exist")
+	}
+
+	for _, file := range files {
+		layer := viper.New()
+		layer.SetConfigType("yaml")
+		layer.SetConfigFile(file)
+		if err := layer.ReadInConfig(); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		if viper.GetBool("verbose") {
+			fmt.Println("CONFIG: reading", file)
+		}
+		recordOrigin(layer.AllKeys(), file)
+		viper.MergeConfigMap(layer.AllSettings())
+	}
+
+	// the most specific file is the one reported as in use, and passed on to generated commands
+	viper.SetConfigFile(files[len(files)-1])
+	return nil
+}
+
+func recordOrigin(keys []string, origin string) {
+	for _, key := range keys {
+		configorigin[key] = origin
+	}
+}
+
+// settingOrigin describes where the current value of key came from
+func settingOrigin(key string) string {
+	if f := pflag.Lookup(key); f != nil && f.Changed {
+		return "flag --" + key
+	}
+	env := "SNITCHIT_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+	if _, ok := os.LookupEnv(env); ok {
+		return "env " + env
+	}
+	if origin, ok := configorigin[key]; ok {
+		return origin
+	}
+	return "default"
+}
@@ -0,0 +1,155 @@
+package main
+
+// config_test.go
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigLayering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	repo := filepath.Join(dir, "repo")
+	sub := filepath.Join(repo, "sub")
+	files := map[string]string{
+		"xdg/snitchit/config.yaml": "layer-a: xdg\nlayer-b: xdg\nlayer-c: xdg\nlayer-d: xdg\nlayer-e: xdg\nlayer-f: xdg\n",
+		".snitchit.yaml":           "layer-a: outside the repo\n",
+		"repo/.snitchit.yaml":      "layer-b: repo\nlayer-c: repo\nlayer-d: repo\nlayer-e: repo\n",
+		"repo/sub/.snitchit.yaml":  "layer-c: sub\nlayer-d: sub\nlayer-e: sub\n",
+		"repo/sub/config.yaml":     "layer-d: cwd\nlayer-e: cwd\n",
+		"explicit.yaml":            "layer-e: explicit\nlayer-f: explicit\n",
+	}
+	os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(sub)
+	for name, value := range map[string]string{
+		"XDG_CONFIG_HOME":  filepath.Join(dir, "xdg"),
+		"SNITCHIT_CONFIG":  filepath.Join(dir, "explicit.yaml"),
+		"SNITCHIT_LAYER_F": "env",
+	} {
+		if previous, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Setenv(name, value)
+	}
+
+	// files are layered from the least to the most specific, stopping at the root of the repository
+	var found []string
+	for _, file := range configFiles() {
+		if !strings.HasPrefix(file, "/etc/") {
+			found = append(found, file)
+		}
+	}
+	want := []string{
+		filepath.Join(dir, "xdg/snitchit/config.yaml"),
+		filepath.Join(repo, ".snitchit.yaml"),
+		filepath.Join(sub, ".snitchit.yaml"),
+		"config.yaml",
+		filepath.Join(dir, "explicit.yaml"),
+	}
+	if !equalStrings(found, want) {
+		t.Errorf("configFiles() = %q, want %q", found, want)
+	}
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key    string
+		value  string
+		origin string
+	}{
+		{"layer-a", "xdg", filepath.Join(dir, "xdg/snitchit/config.yaml")},
+		{"layer-b", "repo", filepath.Join(repo, ".snitchit.yaml")},
+		{"layer-c", "sub", filepath.Join(sub, ".snitchit.yaml")},
+		{"layer-d", "cwd", "config.yaml"},
+		{"layer-e", "explicit", filepath.Join(dir, "explicit.yaml")},
+		{"layer-f", "env", "env SNITCHIT_LAYER_F"},
+	}
+	for _, test := range tests {
+		if value, origin := viper.GetString(test.key), settingOrigin(test.key); value != test.value || origin != test.origin {
+			t.Errorf("%s = %q from %s, want %q from %s", test.key, value, origin, test.value, test.origin)
+		}
+	}
+	if used := viper.ConfigFileUsed(); used != filepath.Join(dir, "explicit.yaml") {
+		t.Errorf("config file used = %s, want the explicit one", used)
+	}
+}
+
+func TestConfigFilesWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	home := filepath.Join(dir, "home")
+	shared := filepath.Join(home, "shared")
+	project := filepath.Join(shared, "project")
+	sub := filepath.Join(project, "sub")
+	os.MkdirAll(sub, 0755)
+	os.Chmod(shared, 0777)
+	for _, d := range []string{dir, home, shared, project, sub} {
+		ioutil.WriteFile(filepath.Join(d, ".snitchit.yaml"), []byte("defaultsnitch: abc\n"), 0644)
+	}
+	// only root can give a file to another user
+	foreign := os.Getuid() == 0 && os.Chown(filepath.Join(project, ".snitchit.yaml"), 12345, 12345) == nil
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(sub)
+	for name, value := range map[string]string{
+		"HOME":            home,
+		"XDG_CONFIG_HOME": filepath.Join(dir, "xdg"),
+		"SNITCHIT_CONFIG": "",
+	} {
+		if previous, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Setenv(name, value)
+	}
+
+	// the walk stops at the home directory, and leaves out files other users could have written
+	var found []string
+	warnings := captureStdout(t, func() {
+		for _, file := range configFiles() {
+			if !strings.HasPrefix(file, "/etc/") {
+				found = append(found, file)
+			}
+		}
+	})
+	want := []string{filepath.Join(home, ".snitchit.yaml"), filepath.Join(sub, ".snitchit.yaml")}
+	if !foreign {
+		want = []string{want[0], filepath.Join(project, ".snitchit.yaml"), want[1]}
+	}
+	if !equalStrings(found, want) {
+		t.Errorf("configFiles() = %q, want %q", found, want)
+	}
+	if !strings.Contains(warnings, "WARNING: Ignoring "+filepath.Join(shared, ".snitchit.yaml")+", other users can write to its directory") {
+		t.Errorf("configFiles() warned %q, want the shared directory", warnings)
+	}
+	if foreign && !strings.Contains(warnings, "WARNING: Ignoring "+filepath.Join(project, ".snitchit.yaml")+", it is owned by another user") {
+		t.Errorf("configFiles() warned %q, want the file owned by another user", warnings)
+	}
+}
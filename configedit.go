@@ -0,0 +1,329 @@
+package main
+
+// configedit.go
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func configCommand() {
+	key := strings.ToLower(pflag.Arg(2))
+
+	switch strings.ToLower(pflag.Arg(1)) {
+	case "get":
+		if key == "" {
+			fmt.Println("ERROR: No key given, use \"snitchit config get [key]\"")
+			os.Exit(1)
+		}
+		if !viper.IsSet(key) {
+			fmt.Println("ERROR:", key, "is not set")
+			os.Exit(1)
+		}
+		value := viper.Get(key)
+		if !viper.GetBool("show-secrets") {
+			// secret settings are found by their last part, so profiles.prod.apikey is hidden as well as apikey
+			parts := strings.Split(key, ".")
+			last := parts[len(parts)-1]
+			value = redactSettings(map[string]interface{}{last: value})[last]
+		}
+		switch value := value.(type) {
+		case string, bool, int, float64, time.Duration:
+			fmt.Println(redactSecrets(fmt.Sprint(value)))
+		default:
+			out, _ := yaml.Marshal(value)
+			fmt.Print(redactSecrets(string(out)))
+		}
+	case "set":
+		if key == "" || pflag.NArg() < 4 {
+			fmt.Println("ERROR: No key or value given, use \"snitchit config set [key] [value]\"")
+			os.Exit(1)
+		}
+		value, err := parseConfigValue(key, pflag.Arg(3))
+		if err != nil {
+			fmt.Println("ERROR:", err)
+			os.Exit(1)
+		}
+		editConfig(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+			return setConfigValue(config, strings.Split(key, "."), value), nil
+		})
+	case "unset":
+		if key == "" {
+			fmt.Println("ERROR: No key given, use \"snitchit config unset [key]\"")
+			os.Exit(1)
+		}
+		if err := checkConfigKey(key); err != nil {
+			fmt.Println("ERROR:", err)
+			os.Exit(1)
+		}
+		editConfig(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+			config, found := unsetConfigValue(config, strings.Split(key, "."))
+			if !found {
+				return nil, fmt.Errorf("%s is not set in %s", key, configEditFile())
+			}
+			return config, nil
+		})
+	case "add-snitch":
+		token := pflag.Arg(2)
+		if token == "" {
+			fmt.Println("ERROR: No snitch given, use \"snitchit config add-snitch [snitch]\"")
+			os.Exit(1)
+		}
+		editConfig(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+			var snitches []interface{}
+			if value, ok := getConfigValue(config, []string{"snitches"}); ok {
+				snitches, _ = value.([]interface{})
+			}
+			for _, existing := range snitches {
+				if fmt.Sprint(existing) == token {
+					return nil, fmt.Errorf("%s is already in snitches", token)
+				}
+			}
+			return setConfigValue(config, []string{"snitches"}, append(snitches, token)), nil
+		})
+	case "lint":
+		lintCommand()
+	case "schema":
+		schemaCommand()
+	default:
+		fmt.Println("ERROR: Invalid config command", pflag.Arg(1), ". Please choose either \"get\", \"set\", \"unset\", \"add-snitch\", \"lint\" or \"schema\"")
+		os.Exit(1)
+	}
+}
+
+// configEditFile is the file config commands edit, the most specific config file found or --config
+func configEditFile() string {
+	if viper.ConfigFileUsed() != "" {
+		return viper.ConfigFileUsed()
+	}
+	return viper.GetString("config")
+}
+
+// editConfig applies edit to the config file, keeping the order of its keys and a backup of the original.
+// Comments are not kept.
+func editConfig(edit func(yaml.MapSlice) (yaml.MapSlice, error)) {
+	path := configEditFile()
+
+	original, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("ERROR: Cannot read", path, ":", err)
+		os.Exit(1)
+	}
+
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(original, &config); err != nil {
+		fmt.Println("ERROR: Cannot parse", path, ":", err)
+		os.Exit(1)
+	}
+
+	config, err = edit(config)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
+	}
+
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		fmt.Println("ERROR: Cannot write config:", err)
+		os.Exit(1)
+	}
+
+	mode := os.FileMode(0600)
+	if original != nil {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode()
+		}
+		backup := path + ".bak." + time.Now().Format("20060102150405")
+		if err := ioutil.WriteFile(backup, original, 0600); err != nil {
+			fmt.Println("ERROR: Cannot write backup:", err)
+			os.Exit(1)
+		}
+		if verbose {
+			fmt.Println("Config: backup saved to", backup)
+		}
+	}
+
+	// write the new file beside the old one and swap it in, so a failed write never leaves half a config
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".snitchit-")
+	if err == nil {
+		_, err = temp.Write(out)
+		temp.Close()
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		if temp != nil {
+			os.Remove(temp.Name())
+		}
+		fmt.Println("ERROR: Cannot write", path, ":", err)
+		os.Exit(1)
+	}
+
+	if !silent {
+		fmt.Println("Updated", path)
+	}
+}
+
+// schemaFor finds the schema of the dotted key path, nil when the schema does not know the key
+func schemaFor(schema *configSchema, keys []string) *configSchema {
+	for _, key := range keys {
+		if schema == nil {
+			return nil
+		}
+		if property, ok := schema.Properties[key]; ok {
+			schema = property
+			continue
+		}
+		schema, _ = schema.AdditionalProperties.(*configSchema)
+	}
+	return schema
+}
+
+// checkConfigKey returns an error when the schema does not know the dotted key path, worded as config lint words it
+func checkConfigKey(key string) error {
+	var config interface{}
+	keys := strings.Split(key, ".")
+	for i := len(keys) - 1; i >= 0; i-- {
+		config = yaml.MapSlice{{Key: keys[i], Value: config}}
+	}
+	if problems := lintValue("", config, rootSchema()); len(problems) > 0 {
+		return fmt.Errorf("%s", problems[0])
+	}
+	return nil
+}
+
+// parseConfigValue turns the text given to config set in to the value to save, by the type the schema gives key.
+// Text settings are kept exactly as given, so a token such as 0123 or 1e10 is not read as a number.
+func parseConfigValue(key string, text string) (interface{}, error) {
+	if err := checkConfigKey(key); err != nil {
+		return nil, err
+	}
+	schema := schemaFor(rootSchema(), strings.Split(key, "."))
+
+	var value interface{}
+	switch schema.Type {
+	case "boolean":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be true or false", key)
+		}
+		value = b
+	case "integer":
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be a number", key)
+		}
+		value = i
+	case "array":
+		// lists are yaml, "[a, b]", and a single value is a list of one
+		var list []interface{}
+		if schema.Items != nil && schema.Items.Type == "string" {
+			var strs []string
+			if err := yaml.Unmarshal([]byte(text), &strs); err != nil {
+				strs = []string{text}
+			}
+			for _, str := range strs {
+				list = append(list, str)
+			}
+		} else if err := yaml.Unmarshal([]byte(text), &list); err != nil {
+			list = []interface{}{text}
+		}
+		value = list
+	case "object":
+		var object yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(text), &object); err != nil {
+			return nil, fmt.Errorf("%s should be a map: %s", key, err)
+		}
+		value = object
+	default:
+		value = text
+	}
+
+	if problems := lintValue(key, value, schema); len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return value, nil
+}
+
+func getConfigValue(config yaml.MapSlice, keys []string) (interface{}, bool) {
+	for _, item := range config {
+		if !strings.EqualFold(fmt.Sprint(item.Key), keys[0]) {
+			continue
+		}
+		if len(keys) == 1 {
+			return item.Value, true
+		}
+		if nested, ok := item.Value.(yaml.MapSlice); ok {
+			return getConfigValue(nested, keys[1:])
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// setConfigValue sets the dotted key path, replacing the value in place or adding it to the end
+func setConfigValue(config yaml.MapSlice, keys []string, value interface{}) yaml.MapSlice {
+	for i, item := range config {
+		if !strings.EqualFold(fmt.Sprint(item.Key), keys[0]) {
+			continue
+		}
+		if len(keys) == 1 {
+			config[i].Value = value
+		} else {
+			nested, _ := item.Value.(yaml.MapSlice)
+			config[i].Value = setConfigValue(nested, keys[1:], value)
+		}
+		return config
+	}
+
+	if len(keys) == 1 {
+		return append(config, yaml.MapItem{Key: keys[0], Value: value})
+	}
+	return append(config, yaml.MapItem{Key: keys[0], Value: setConfigValue(nil, keys[1:], value)})
+}
+
+func unsetConfigValue(config yaml.MapSlice, keys []string) (yaml.MapSlice, bool) {
+	for i, item := range config {
+		if !strings.EqualFold(fmt.Sprint(item.Key), keys[0]) {
+			continue
+		}
+		if len(keys) == 1 {
+			return append(config[:i], config[i+1:]...), true
+		}
+		nested, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return config, false
+		}
+		nested, found := unsetConfigValue(nested, keys[1:])
+		config[i].Value = nested
+		return config, found
+	}
+	return config, false
+}
+
+// saveAlias writes token in to the aliases map of the config file
+func saveAlias(alias string, token string) {
+	editConfig(func(config yaml.MapSlice) (yaml.MapSlice, error) {
+		return setConfigValue(config, []string{"aliases", alias}, token), nil
+	})
+}
+
+// resolveSnitch turns an alias from the aliases map of the config in to its token, anything else is returned as it is
+func resolveSnitch(ref string) string {
+	if token, ok := viper.GetStringMapString("aliases")[strings.ToLower(ref)]; ok && token != "" {
+		return token
+	}
+	return ref
+}
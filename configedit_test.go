@@ -0,0 +1,183 @@
+package main
+
+// configedit_test.go
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetConfigValue(t *testing.T) {
+	original := "apikey: abc\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\n"
+
+	tests := []struct {
+		key   string
+		value interface{}
+		want  string
+	}{
+		{"apikey", "def", "apikey: def\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\n"},
+		{"APIKEY", "def", "apikey: def\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\n"},
+		{"smtp.port", 2525, "apikey: abc\nsmtp:\n  host: mail\n  port: 2525\nsnitches:\n- a1\n"},
+		{"smtp.from", "me@example.com", "apikey: abc\nsmtp:\n  host: mail\n  port: 25\n  from: me@example.com\nsnitches:\n- a1\n"},
+		{"plan", "small", "apikey: abc\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\nplan: small\n"},
+		{"aliases.db", "0123", "apikey: abc\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\naliases:\n  db: \"0123\"\n"},
+		{"snitches", []interface{}{"a1", "b2"}, "apikey: abc\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\n- b2\n"},
+		// setting inside a key which is not a map replaces it with one
+		{"apikey.x", "z", "apikey:\n  x: z\nsmtp:\n  host: mail\n  port: 25\nsnitches:\n- a1\n"},
+	}
+	for _, test := range tests {
+		var config yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(original), &config); err != nil {
+			t.Fatal(err)
+		}
+		config = setConfigValue(config, strings.Split(strings.ToLower(test.key), "."), test.value)
+		out, err := yaml.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != test.want {
+			t.Errorf("setConfigValue(%s, %v) =\n%s\nwant\n%s", test.key, test.value, out, test.want)
+		}
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		key   string
+		text  string
+		want  interface{}
+		fails bool
+	}{
+		// text settings are kept as given, whatever yaml would make of them
+		{"defaultsnitch", "0123", "0123", false},
+		{"apikey", "1e10", "1e10", false},
+		{"aliases.db", "0x1f", "0x1f", false},
+		{"smtp.password", "yes", "yes", false},
+		{"plan", "small", "small", false},
+		{"unknown", "0123", nil, true},
+		{"smtp.portt", "25", nil, true},
+		{"output-lines", "3", 3, false},
+		{"output-lines", "3a", nil, true},
+		{"silent", "true", true, false},
+		{"silent", "maybe", nil, true},
+		{"timeout", "90s", "90s", false},
+		{"timeout", "90", nil, true},
+		{"smtp.port", "2525", 2525, false},
+		{"smtp.port", "25a", nil, true},
+		{"smtp.starttls", "true", true, false},
+		{"alert", "loud", nil, true},
+		{"snitches", "[0123, abc]", []interface{}{"0123", "abc"}, false},
+		{"snitches", "0123", []interface{}{"0123"}, false},
+		{"profiles.prod.interval", "daily", "daily", false},
+		{"profiles.prod.interval", "often", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseConfigValue(test.key, test.text)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseConfigValue(%q, %q) = %#v, want an error", test.key, test.text, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseConfigValue(%q, %q) = %#v, %v, want %#v", test.key, test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestCheckConfigKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"defaultsnitch", ""},
+		{"smtp.port", ""},
+		{"aliases.db", ""},
+		{"profiles.prod.apikey", ""},
+		// set and unset refuse keys the schema does not know, as config lint would
+		{"defualtsnitch", "defualtsnitch is not a known setting, did you mean defaultsnitch?"},
+		{"smtp.portt", "smtp.portt is not a known setting, did you mean port?"},
+		{"profiles.prod.intervl", "profiles.prod.intervl is not a known setting, did you mean interval?"},
+		{"nothinglikeit", "nothinglikeit is not a known setting"},
+	}
+	for _, test := range tests {
+		err := checkConfigKey(test.key)
+		if got := fmt.Sprint(err); (test.want == "" && err != nil) || (test.want != "" && got != test.want) {
+			t.Errorf("checkConfigKey(%q) = %v, want %q", test.key, err, test.want)
+		}
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-configedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s bool) { silent = s }(silent)
+	silent = true
+	_, cleanup := fakeAPI(t, nil)
+	defer cleanup()
+
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("apikey: abc\nsnitches:\n- a1\n"), 0640)
+	viper.SetConfigFile(path)
+
+	config := func(args ...string) string {
+		return captureStdout(t, func() {
+			pflag.CommandLine.Parse(append([]string{"config"}, args...))
+			configCommand()
+		})
+	}
+
+	config("set", "defaultsnitch", "0123")
+	config("set", "silent", "true")
+	config("add-snitch", "b2")
+	config("unset", "apikey")
+	viper.Set("interval", "daily")
+	viper.Set("alert", "basic")
+	viper.Set("save-as", "db")
+	captureStdout(t, func() { createSnitch(newSnitch{Name: "db backups", Interval: "daily"}) })
+	viper.Set("interval", nil)
+	viper.Set("alert", nil)
+	viper.Set("save-as", nil)
+
+	edited, _ := ioutil.ReadFile(path)
+	if want := "snitches:\n- a1\n- b2\ndefaultsnitch: \"0123\"\nsilent: true\naliases:\n  db: new1\n"; string(edited) != want {
+		t.Errorf("edited config =\n%s\nwant\n%s", edited, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("edited config mode = %v, %v, want it kept at 0640", info.Mode(), err)
+	}
+	if backups, _ := filepath.Glob(path + ".bak.*"); len(backups) == 0 {
+		t.Errorf("no backup of the config written")
+	}
+
+	// config get reads the layered config, hiding secrets unless asked
+	viper.Set("aliases", map[string]interface{}{"db": "new1"})
+	viper.Set("apikey", "plainkey")
+	defer viper.Set("aliases", nil)
+	defer viper.Set("apikey", nil)
+	defer viper.Set("show-secrets", nil)
+	if got := config("get", "aliases.db"); got != "new1\n" {
+		t.Errorf("config get aliases.db = %q, want new1", got)
+	}
+	if got := config("get", "apikey"); got != "[REDACTED]\n" {
+		t.Errorf("config get apikey = %q, want it redacted", got)
+	}
+	viper.Set("show-secrets", true)
+	if got := config("get", "apikey"); got != "plainkey\n" {
+		t.Errorf("config get --show-secrets apikey = %q, want plainkey", got)
+	}
+	if got := resolveSnitch("DB"); got != "new1" {
+		t.Errorf("resolveSnitch(DB) = %q, want the aliased token", got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+// cron.go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field crontab schedule, each field is a bitset of allowed values
+type cronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domstar bool
+	dowstar bool
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses standard and vixie cron schedules such as "*/15 * * * *", "0 2 * * mon-fri" or "@daily"
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := cronShortcuts[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported schedule %s", spec)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in schedule %q, found %d", spec, len(fields))
+	}
+
+	var err error
+	schedule := &cronSchedule{}
+	if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+
+	// 7 is also sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow = schedule.dow | 1
+	}
+
+	schedule.domstar = strings.HasPrefix(fields[2], "*")
+	schedule.dowstar = strings.HasPrefix(fields[4], "*")
+
+	return schedule, nil
+}
+
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		var low, high int
+		switch {
+		case part == "*":
+			low, high = min, max
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = cronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = cronValue(part, min, max, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if step != 1 {
+				// vixie cron treats "5/10" as "5-max/10"
+				high = max
+			}
+		}
+
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+
+		for value := low; value <= high; value += step {
+			bits = bits | 1<<uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func cronValue(value string, min int, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.ToLower(value) == name {
+			return i + min, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid value %q, must be %d-%d", value, min, max)
+	}
+	return number, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// when both day fields are restricted cron runs when either matches
+	if !c.domstar && !c.dowstar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// next returns the first time after t the schedule fires, or the zero time if it never does
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// snitchIntervals are the snitch check in intervals from shortest to longest
+var snitchIntervals = []struct {
+	name   string
+	period time.Duration
+}{
+	{"15_minute", 15 * time.Minute},
+	{"30_minute", 30 * time.Minute},
+	{"hourly", time.Hour},
+	{"daily", 24 * time.Hour},
+	{"weekly", 7 * 24 * time.Hour},
+	{"monthly", 31 * 24 * time.Hour},
+}
+
+func intervalPeriod(interval string) time.Duration {
+	for _, i := range snitchIntervals {
+		if i.name == strings.ToLower(interval) {
+			return i.period
+		}
+	}
+	return 0
+}
+
+// maxGap returns the longest time between two runs of the schedule over roughly the next year
+func (c *cronSchedule) maxGap(from time.Time) time.Duration {
+	return scheduleGap(c.next, from)
+}
+
+// scheduleGap returns the longest time between two times returned by next over roughly the next year
+func scheduleGap(next func(time.Time) time.Time, from time.Time) time.Duration {
+	var gap time.Duration
+	limit := from.AddDate(1, 1, 0)
+	last := next(from)
+	for runs := 0; runs < 20000 && !last.IsZero() && last.Before(limit); runs++ {
+		after := next(last)
+		if after.IsZero() {
+			// never runs again, so no interval can cover it
+			return 0
+		}
+		if after.Sub(last) > gap {
+			gap = after.Sub(last)
+		}
+		last = after
+	}
+	return gap
+}
+
+// nearestInterval returns the shortest snitch interval which every gap between runs fits in to
+func nearestInterval(gap time.Duration) (string, bool) {
+	if gap <= 0 {
+		return "", false
+	}
+	for _, i := range snitchIntervals {
+		if gap <= i.period {
+			return i.name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package main
+
+// cron_test.go
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec  string
+		fails bool
+	}{
+		{"* * * * *", false},
+		{"*/15 * * * *", false},
+		{"0 2 * * mon-fri", false},
+		{"30 4 1,15 * *", false},
+		{"0 0 * jan,jul sun", false},
+		{"0 0 * * 7", false},
+		{"0-30/10 9-17 * * 1-5", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"@reboot", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"* * * * fun", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+	}
+	for _, test := range tests {
+		_, err := parseCron(test.spec)
+		if test.fails && err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", test.spec)
+		}
+		if !test.fails && err != nil {
+			t.Errorf("parseCron(%q) = %v", test.spec, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// a wednesday
+	from := time.Date(2026, time.January, 14, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.January, 14, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.January, 14, 10, 15, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2026, time.January, 15, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.January, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 9 * * sat", time.Date(2026, time.January, 17, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2026, time.January, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// with both day fields restricted either one matching is enough
+		{"0 0 20 * fri", time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 feb *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) = %v", test.spec, err)
+			continue
+		}
+		if got := schedule.next(from); !got.Equal(test.want) {
+			t.Errorf("%q next after %s = %s, want %s", test.spec, from, got, test.want)
+		}
+	}
+}
+
+func TestNearestInterval(t *testing.T) {
+	tests := []struct {
+		spec     string
+		interval string
+		ok       bool
+	}{
+		{"*/5 * * * *", "15_minute", true},
+		{"*/15 * * * *", "15_minute", true},
+		{"*/20 * * * *", "30_minute", true},
+		{"0 * * * *", "hourly", true},
+		{"0 */2 * * *", "daily", true},
+		{"30 2 * * *", "daily", true},
+		{"0 9 * * mon-fri", "weekly", true},
+		{"0 0 * * sun", "weekly", true},
+		{"0 0 1 * *", "monthly", true},
+		{"0 0 1 */2 *", "", false},
+		{"0 0 1 1 *", "", false},
+		{"0 0 31 feb *", "", false},
+	}
+	from := time.Date(2026, time.January, 14, 10, 7, 0, 0, time.UTC)
+	for _, test := range tests {
+		schedule, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) = %v", test.spec, err)
+			continue
+		}
+		interval, ok := nearestInterval(schedule.maxGap(from))
+		if interval != test.interval || ok != test.ok {
+			t.Errorf("nearestInterval of %q = %q, %v, want %q, %v", test.spec, interval, ok, test.interval, test.ok)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+// crontab.go
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// crontabEntry is one line of a crontab, commands are only set on schedule lines
+type crontabEntry struct {
+	line     string
+	schedule string
+	user     string
+	command  string
+	name     string
+	interval string
+	token    string
+	skip     string
+}
+
+var crontabEnvLine = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+
+func cronCommand() {
+	switch strings.ToLower(pflag.Arg(1)) {
+	case "import":
+		importCrontab(pflag.Arg(2))
+	default:
+		fmt.Println("ERROR: Invalid cron command", pflag.Arg(1), ". Please use \"snitchit cron import [crontab]\"")
+		os.Exit(1)
+	}
+}
+
+func importCrontab(crontab string) {
+	if crontab == "" {
+		fmt.Println("ERROR: No crontab given, use \"snitchit cron import /etc/crontab\"")
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(crontab)
+	if err != nil {
+		fmt.Println("ERROR: Cannot read crontab:", err)
+		os.Exit(1)
+	}
+
+	// the system crontab and cron.d files have a user field before the command
+	system := viper.GetBool("system")
+	if abs, err := filepath.Abs(crontab); err == nil {
+		if abs == "/etc/crontab" || filepath.Dir(abs) == "/etc/cron.d" {
+			system = true
+		}
+	}
+
+	entries := parseCrontab(string(data), system)
+
+	apply := viper.GetString("output") != "" || viper.GetBool("in-place")
+
+	existing, err := listSnitches()
+	if err != nil {
+		fmt.Println("ERROR: Cannot list snitches:", err)
+		os.Exit(1)
+	}
+	count := len(existing)
+	bytoken := make(map[string]string)
+	for _, s := range existing {
+		bytoken[s.Name] = s.Token
+	}
+
+	hostname, _ := os.Hostname()
+	hostname = strings.Split(hostname, ".")[0]
+	used := make(map[string]int)
+
+	for i := range entries {
+		e := &entries[i]
+		if e.command == "" || e.skip != "" {
+			continue
+		}
+
+		schedule, err := parseCron(e.schedule)
+		if err != nil {
+			e.skip = err.Error()
+			continue
+		}
+
+		gap := schedule.maxGap(time.Now().UTC())
+		interval, ok := nearestInterval(gap)
+		if !ok {
+			e.skip = "no snitch interval fits schedule " + e.schedule
+			continue
+		}
+		e.interval = interval
+
+		// name snitches after the host and program, numbering repeats so names stay stable between imports
+		program := filepath.Base(strings.Fields(e.command)[0])
+		used[program]++
+		e.name = hostname + " " + program
+		if used[program] > 1 {
+			e.name = fmt.Sprintf("%s %d", e.name, used[program])
+		}
+
+		if token, ok := bytoken[e.name]; ok {
+			e.token = token
+			continue
+		}
+
+		// a dry run counts the snitches it would create too, so it skips the same entries a real import would
+		if err := checkQuota(viper.GetString("plan"), count); err != nil {
+			e.skip = err.Error()
+			continue
+		}
+		count++
+
+		if !apply {
+			continue
+		}
+
+		created, err := postSnitch(newSnitch{
+			Name:      e.name,
+			Interval:  e.interval,
+			AlertType: "basic",
+			Notes:     "Imported from " + crontab + ": " + e.line,
+			Tags:      []string{"cron", hostname},
+		})
+		var after *oneSnitch
+		if err == nil {
+			after = &created
+		}
+		recordAudit("create", created.Token, nil, after, err)
+		if err != nil {
+			fmt.Println("ERROR: Cannot create snitch", e.name, ":", err)
+			os.Exit(1)
+		}
+		e.token = created.Token
+		bytoken[e.name] = created.Token
+	}
+
+	displayCrontabPlan(entries, apply)
+
+	rewritten := rewriteCrontab(entries)
+
+	switch {
+	case viper.GetBool("in-place"):
+		backup := crontab + ".bak." + time.Now().Format("20060102150405")
+		if err := ioutil.WriteFile(backup, data, 0600); err != nil {
+			fmt.Println("ERROR: Cannot write backup:", err)
+			os.Exit(1)
+		}
+		info, _ := os.Stat(crontab)
+		if err := ioutil.WriteFile(crontab, []byte(rewritten), info.Mode()); err != nil {
+			fmt.Println("ERROR: Cannot rewrite crontab:", err)
+			os.Exit(1)
+		}
+		if !silent {
+			fmt.Println("Rewrote", crontab, "backup saved to", backup)
+		}
+	case viper.GetString("output") != "":
+		if err := ioutil.WriteFile(viper.GetString("output"), []byte(rewritten), 0644); err != nil {
+			fmt.Println("ERROR: Cannot write", viper.GetString("output"), ":", err)
+			os.Exit(1)
+		}
+		if !silent {
+			fmt.Println("Wrote", viper.GetString("output"))
+		}
+	default:
+		fmt.Println("\nDry run, no snitches created. Rewritten crontab:")
+		fmt.Println()
+		fmt.Print(rewritten)
+	}
+}
+
+func parseCrontab(crontab string, system bool) []crontabEntry {
+	var entries []crontabEntry
+
+	scanner := bufio.NewScanner(strings.NewReader(crontab))
+	for scanner.Scan() {
+		e := crontabEntry{line: scanner.Text()}
+		trimmed := strings.TrimSpace(e.line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || crontabEnvLine.MatchString(trimmed) {
+			entries = append(entries, e)
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		schedulefields := 5
+		if strings.HasPrefix(fields[0], "@") {
+			schedulefields = 1
+		}
+		if system {
+			schedulefields++
+		}
+		if len(fields) <= schedulefields {
+			e.skip = "cannot parse line"
+			entries = append(entries, e)
+			continue
+		}
+
+		if system {
+			e.user = fields[schedulefields-1]
+			e.schedule = strings.Join(fields[:schedulefields-1], " ")
+		} else {
+			e.schedule = strings.Join(fields[:schedulefields], " ")
+		}
+
+		// keep the command exactly as written, including its spacing
+		rest := trimmed
+		for i := 0; i < schedulefields; i++ {
+			rest = strings.TrimLeft(rest, " \t")
+			rest = rest[len(fields[i]):]
+		}
+		e.command = strings.TrimSpace(rest)
+
+		switch {
+		case strings.Contains(e.command, "snitchit"):
+			e.skip = "already uses snitchit"
+		case strings.ToLower(e.schedule) == "@reboot":
+			e.skip = "@reboot has no interval"
+		case strings.Contains(strings.Replace(e.command, `\%`, "", -1), "%"):
+			e.skip = "uses % for stdin, wrap by hand"
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+func displayCrontabPlan(entries []crontabEntry, apply bool) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "Schedule", "Interval", "Snitch", "Name", "Command")
+
+	for _, e := range entries {
+		if e.command == "" && e.skip == "" {
+			continue
+		}
+		if e.skip != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.schedule, "SKIPPED", e.skip, "", e.command)
+			continue
+		}
+		token := e.token
+		if token == "" {
+			token = "(new)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.schedule, e.interval, token, e.name, e.command)
+	}
+	w.Flush()
+}
+
+// rewriteCrontab wraps each importable command with snitchit run
+func rewriteCrontab(entries []crontabEntry) string {
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "snitchit"
+	}
+
+	configflag := ""
+	if viper.ConfigFileUsed() != "" {
+		if abs, err := filepath.Abs(viper.ConfigFileUsed()); err == nil {
+			configflag = " --config " + shellQuote(abs)
+		}
+	}
+
+	var out strings.Builder
+	for _, e := range entries {
+		if e.command == "" || e.skip != "" || e.interval == "" {
+			out.WriteString(e.line + "\n")
+			continue
+		}
+
+		token := e.token
+		if token == "" {
+			token = "NEW_SNITCH_TOKEN"
+		}
+
+		fields := e.schedule
+		if e.user != "" {
+			fields = fields + " " + e.user
+		}
+		out.WriteString("# snitchit: " + e.name + "\n")
+		out.WriteString(fmt.Sprintf("%s %s%s --silent run --snitch %s -- %s\n", fields, binary, configflag, token, shellQuote(e.command)))
+	}
+
+	return out.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
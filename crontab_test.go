@@ -0,0 +1,136 @@
+package main
+
+// crontab_test.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI serves existing from GET /v1/snitches and /v1/snitches/<token>, and records each snitch created with POST /v1/snitches
+func fakeAPI(t *testing.T, existing []oneSnitch) (func() []newSnitch, func()) {
+	var mu sync.Mutex
+	var created []newSnitch
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/v1/snitches":
+			json.NewEncoder(w).Encode(existing)
+		case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/v1/snitches/"):
+			for _, s := range existing {
+				if s.Token == strings.TrimPrefix(req.URL.Path, "/v1/snitches/") {
+					json.NewEncoder(w).Encode(s)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(dmsResp{Error: "not_found"})
+		case req.Method == "POST" && req.URL.Path == "/v1/snitches":
+			var s newSnitch
+			if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			created = append(created, s)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(oneSnitch{Token: fmt.Sprintf("new%d", len(created)), Name: s.Name, Interval: s.Interval})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	previous := apiurl
+	apiurl = server.URL + "/v1"
+	return func() []newSnitch {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]newSnitch(nil), created...)
+		}, func() {
+			apiurl = previous
+			server.Close()
+		}
+}
+
+func TestImportCrontab(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-crontab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s bool) { silent = s }(silent)
+	silent = true
+
+	hostname, _ := os.Hostname()
+	hostname = strings.Split(hostname, ".")[0]
+	binary, _ := os.Executable()
+	// a config loaded by an earlier test is passed on as well
+	if viper.ConfigFileUsed() != "" {
+		config, _ := filepath.Abs(viper.ConfigFileUsed())
+		binary += " --config " + shellQuote(config)
+	}
+
+	created, cleanup := fakeAPI(t, []oneSnitch{{Token: "old1", Name: hostname + " rsync", Interval: "15_minute"}})
+	defer cleanup()
+
+	crontab := filepath.Join(dir, "crontab")
+	ioutil.WriteFile(crontab, []byte(`MAILTO=root
+# nightly jobs
+0 2 * * * /usr/local/bin/backup.sh --full
+30 3 * * *   /usr/local/bin/backup.sh  --incremental
+*/15 * * * * rsync -a /srv /mnt
+@reboot /usr/local/bin/start.sh
+0 4 * * * snitchit run --snitch abc -- true
+0 6 * * * echo hi | mail -s % root
+`), 0600)
+
+	// a dry run creates nothing and leaves the crontab alone
+	importCrontab(crontab)
+	if got := created(); len(got) != 0 {
+		t.Errorf("dry run created %+v", got)
+	}
+
+	output := filepath.Join(dir, "crontab.new")
+	viper.Set("output", output)
+	defer viper.Set("output", nil)
+	importCrontab(crontab)
+
+	var names []string
+	for _, s := range created() {
+		if s.Interval != "daily" || s.AlertType != "basic" {
+			t.Errorf("created %+v, want a daily basic snitch", s)
+		}
+		names = append(names, s.Name)
+	}
+	if want := []string{hostname + " backup.sh", hostname + " backup.sh 2"}; !equalStrings(names, want) {
+		t.Errorf("created snitches %q, want %q", names, want)
+	}
+
+	rewritten, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `MAILTO=root
+# nightly jobs
+# snitchit: ` + hostname + ` backup.sh
+0 2 * * * ` + binary + ` --silent run --snitch new1 -- '/usr/local/bin/backup.sh --full'
+# snitchit: ` + hostname + ` backup.sh 2
+30 3 * * * ` + binary + ` --silent run --snitch new2 -- '/usr/local/bin/backup.sh  --incremental'
+# snitchit: ` + hostname + ` rsync
+*/15 * * * * ` + binary + ` --silent run --snitch old1 -- 'rsync -a /srv /mnt'
+@reboot /usr/local/bin/start.sh
+0 4 * * * snitchit run --snitch abc -- true
+0 6 * * * echo hi | mail -s % root
+`
+	if string(rewritten) != want {
+		t.Errorf("rewritten crontab:\n%s\nwant:\n%s", rewritten, want)
+	}
+}
@@ -0,0 +1,675 @@
+package main
+
+// dbus.go
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbusConn is a minimal D-Bus client, enough to call methods on the session bus without a D-Bus library
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// dbusObjectPath and dbusSignature are the D-Bus types o and g, which marshal much like strings
+type dbusObjectPath string
+type dbusSignature string
+
+// dbusVariant is the D-Bus type v, a value carrying its own signature
+type dbusVariant struct {
+	Signature string
+	Value     interface{}
+}
+
+// dbusError is an error returned by a method call
+type dbusError struct {
+	Name    string
+	Message string
+}
+
+func (e dbusError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// message types
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusErrorReply   = 3
+	dbusSignal       = 4
+)
+
+// dbusMessage is one message on the bus, its body decoded in to Go values:
+// strings, dbusObjectPath, dbusSignature, dbusVariant, numbers, []byte for ay and []interface{} for other arrays and structs
+type dbusMessage struct {
+	Type        byte
+	Serial      uint32
+	ReplySerial uint32
+	Path        dbusObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// dbusTimeout is how long a whole conversation with the bus may take, the D-Bus default for a method call
+const dbusTimeout = 25 * time.Second
+
+// dialDBus connects to the first address of a bus that answers, such as $DBUS_SESSION_BUS_ADDRESS, and says hello
+func dialDBus(address string) (*dbusConn, error) {
+	if address == "" {
+		return nil, fmt.Errorf("DBUS_SESSION_BUS_ADDRESS is not set, is there a desktop session?")
+	}
+
+	var lasterr error
+	for _, addr := range strings.Split(address, ";") {
+		conn, err := dialDBusAddress(addr)
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(dbusTimeout))
+		c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+		if err := c.auth(); err != nil {
+			conn.Close()
+			lasterr = err
+			continue
+		}
+		if _, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", ""); err != nil {
+			conn.Close()
+			lasterr = err
+			continue
+		}
+		return c, nil
+	}
+	return nil, lasterr
+}
+
+// dialDBusAddress connects to one bus address, "unix:path=/run/user/1000/bus" or "unix:abstract=/tmp/dbus-x"
+func dialDBusAddress(address string) (net.Conn, error) {
+	parts := strings.SplitN(address, ":", 2)
+	if len(parts) != 2 || parts[0] != "unix" {
+		return nil, fmt.Errorf("unsupported D-Bus address %q, only unix sockets are supported", address)
+	}
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value, err := url.PathUnescape(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid D-Bus address %q: %s", address, err)
+		}
+		switch kv[0] {
+		case "path":
+			return net.Dial("unix", value)
+		case "abstract":
+			return net.Dial("unix", "@"+value)
+		}
+	}
+	return nil, fmt.Errorf("D-Bus address %q has no path", address)
+}
+
+// auth authenticates as the user running snitchit, which the bus checks against the credentials of the socket
+func (c *dbusConn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(c.conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("D-Bus authentication failed: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+func (c *dbusConn) close() {
+	c.conn.Close()
+}
+
+// call calls a method and waits for its reply, skipping signals and anything else sent meanwhile
+func (c *dbusConn) call(destination string, path dbusObjectPath, iface string, member string, signature string, args ...interface{}) ([]interface{}, error) {
+	c.serial++
+	data, err := dbusMessage{
+		Type:        dbusMethodCall,
+		Serial:      c.serial,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: destination,
+		Signature:   signature,
+		Body:        args,
+	}.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := readDBusMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if reply.ReplySerial != c.serial {
+			continue
+		}
+		switch reply.Type {
+		case dbusMethodReturn:
+			return reply.Body, nil
+		case dbusErrorReply:
+			e := dbusError{Name: reply.ErrorName}
+			if len(reply.Body) > 0 {
+				e.Message, _ = reply.Body[0].(string)
+			}
+			return nil, e
+		}
+	}
+}
+
+// marshal encodes the message, little endian
+func (m dbusMessage) marshal() ([]byte, error) {
+	types, err := splitDBusSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(m.Body) {
+		return nil, fmt.Errorf("D-Bus signature %q does not match %d values", m.Signature, len(m.Body))
+	}
+	var body dbusEncoder
+	for i, t := range types {
+		if err := body.encode(t, m.Body[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []interface{}
+	field := func(code byte, signature string, value interface{}) {
+		fields = append(fields, []interface{}{code, dbusVariant{signature, value}})
+	}
+	if m.Path != "" {
+		field(1, "o", m.Path)
+	}
+	if m.Interface != "" {
+		field(2, "s", m.Interface)
+	}
+	if m.Member != "" {
+		field(3, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		field(4, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		field(5, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		field(6, "s", m.Destination)
+	}
+	if m.Sender != "" {
+		field(7, "s", m.Sender)
+	}
+	if m.Signature != "" {
+		field(8, "g", dbusSignature(m.Signature))
+	}
+
+	// endianness, type, flags, protocol version, body length, serial, header fields
+	header := dbusEncoder{buf: []byte{'l', m.Type, 0, 1}}
+	header.uint32(uint32(len(body.buf)))
+	header.uint32(m.Serial)
+	if err := header.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	header.align(8)
+	return append(header.buf, body.buf...), nil
+}
+
+// readDBusMessage reads and decodes one message
+func readDBusMessage(r io.Reader) (dbusMessage, error) {
+	var m dbusMessage
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return m, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return m, fmt.Errorf("invalid D-Bus message endianness %q", fixed[0])
+	}
+	bodylen, fieldslen := order.Uint32(fixed[4:]), order.Uint32(fixed[12:])
+	if bodylen > 1<<27 || fieldslen > 1<<26 {
+		return m, fmt.Errorf("D-Bus message too long")
+	}
+	headerlen := 16 + int(fieldslen)
+	padded := (headerlen + 7) / 8 * 8
+	data := make([]byte, padded+int(bodylen))
+	copy(data, fixed)
+	if _, err := io.ReadFull(r, data[16:]); err != nil {
+		return m, err
+	}
+	m.Type = fixed[1]
+	m.Serial = order.Uint32(fixed[8:])
+
+	header := dbusDecoder{data: data[:headerlen], pos: 12, order: order}
+	fields, err := header.decode("a(yv)")
+	if err != nil {
+		return m, err
+	}
+	for _, f := range fields.([]interface{}) {
+		f := f.([]interface{})
+		value := f[1].(dbusVariant).Value
+		switch f[0].(byte) {
+		case 1:
+			m.Path = dbusObjectPath(fmt.Sprint(value))
+		case 2:
+			m.Interface = fmt.Sprint(value)
+		case 3:
+			m.Member = fmt.Sprint(value)
+		case 4:
+			m.ErrorName = fmt.Sprint(value)
+		case 5:
+			m.ReplySerial, _ = value.(uint32)
+		case 6:
+			m.Destination = fmt.Sprint(value)
+		case 7:
+			m.Sender = fmt.Sprint(value)
+		case 8:
+			m.Signature = fmt.Sprint(value)
+		}
+	}
+
+	types, err := splitDBusSignature(m.Signature)
+	if err != nil {
+		return m, err
+	}
+	body := dbusDecoder{data: data[padded:], order: order}
+	for _, t := range types {
+		value, err := body.decode(t)
+		if err != nil {
+			return m, err
+		}
+		m.Body = append(m.Body, value)
+	}
+	return m, nil
+}
+
+// splitDBusSignature splits a signature in to its complete types, "sa{ss}(ii)" is "s", "a{ss}" and "(ii)"
+func splitDBusSignature(signature string) ([]string, error) {
+	var types []string
+	for signature != "" {
+		n := dbusTypeLength(signature)
+		if n == 0 {
+			return nil, fmt.Errorf("invalid D-Bus signature %q", signature)
+		}
+		types = append(types, signature[:n])
+		signature = signature[n:]
+	}
+	return types, nil
+}
+
+// dbusTypeLength is the length of the first complete type in signature, 0 when it is not valid
+func dbusTypeLength(signature string) int {
+	if signature == "" {
+		return 0
+	}
+	switch signature[0] {
+	case 'a':
+		if n := dbusTypeLength(signature[1:]); n > 0 {
+			return 1 + n
+		}
+		return 0
+	case '(', '{':
+		closing := map[byte]byte{'(': ')', '{': '}'}[signature[0]]
+		for i := 1; i < len(signature); {
+			if signature[i] == closing {
+				return i + 1
+			}
+			n := dbusTypeLength(signature[i:])
+			if n == 0 {
+				return 0
+			}
+			i += n
+		}
+		return 0
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 'h', 's', 'o', 'g', 'v':
+		return 1
+	}
+	return 0
+}
+
+// dbusAlignment is the boundary a value of the type starting with c is aligned to
+func dbusAlignment(c byte) int {
+	switch c {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// encode appends value as the single complete type signature
+func (e *dbusEncoder) encode(signature string, value interface{}) error {
+	wrong := fmt.Errorf("cannot send %T as D-Bus type %s", value, signature)
+
+	switch signature[0] {
+	case 'y':
+		b, ok := value.(byte)
+		if !ok {
+			return wrong
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := value.(bool)
+		if !ok {
+			return wrong
+		}
+		if b {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+	case 'i':
+		i, ok := value.(int32)
+		if !ok {
+			return wrong
+		}
+		e.uint32(uint32(i))
+	case 'u':
+		u, ok := value.(uint32)
+		if !ok {
+			return wrong
+		}
+		e.uint32(u)
+	case 's', 'o', 'g':
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case dbusObjectPath:
+			s = string(v)
+		case dbusSignature:
+			s = string(v)
+		default:
+			return wrong
+		}
+		if signature[0] == 'g' {
+			e.buf = append(e.buf, byte(len(s)))
+		} else {
+			e.uint32(uint32(len(s)))
+		}
+		e.buf = append(append(e.buf, s...), 0)
+	case 'v':
+		v, ok := value.(dbusVariant)
+		if !ok {
+			return wrong
+		}
+		if err := e.encode("g", dbusSignature(v.Signature)); err != nil {
+			return err
+		}
+		return e.encode(v.Signature, v.Value)
+	case 'a':
+		element := signature[1:]
+		e.align(4)
+		at := len(e.buf)
+		e.uint32(0)
+		// the length does not count the padding before the first element
+		e.align(dbusAlignment(element[0]))
+		start := len(e.buf)
+		switch v := value.(type) {
+		case []byte:
+			if element != "y" {
+				return wrong
+			}
+			e.buf = append(e.buf, v...)
+		case []string:
+			for _, s := range v {
+				if err := e.encode(element, s); err != nil {
+					return err
+				}
+			}
+		case map[string]string:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if err := e.encode(element, []interface{}{k, v[k]}); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				if err := e.encode(element, item); err != nil {
+					return err
+				}
+			}
+		default:
+			return wrong
+		}
+		binary.LittleEndian.PutUint32(e.buf[at:], uint32(len(e.buf)-start))
+	case '(', '{':
+		fields, ok := value.([]interface{})
+		types, err := splitDBusSignature(signature[1 : len(signature)-1])
+		if !ok || err != nil || len(fields) != len(types) {
+			return wrong
+		}
+		e.align(8)
+		for i, t := range types {
+			if err := e.encode(t, fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot send D-Bus type %s", signature)
+	}
+	return nil
+}
+
+type dbusDecoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *dbusDecoder) align(n int) error {
+	pos := (d.pos + n - 1) / n * n
+	if pos > len(d.data) {
+		return io.ErrUnexpectedEOF
+	}
+	d.pos = pos
+	return nil
+}
+
+func (d *dbusDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *dbusDecoder) fixed(size int) ([]byte, error) {
+	if err := d.align(size); err != nil {
+		return nil, err
+	}
+	return d.read(size)
+}
+
+// decode reads one value of the single complete type signature
+func (d *dbusDecoder) decode(signature string) (interface{}, error) {
+	switch signature[0] {
+	case 'y':
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'n', 'q':
+		b, err := d.fixed(2)
+		if err != nil {
+			return nil, err
+		}
+		if signature[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'b', 'i', 'u', 'h':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		u := d.order.Uint32(b)
+		switch signature[0] {
+		case 'b':
+			return u != 0, nil
+		case 'i':
+			return int32(u), nil
+		}
+		return u, nil
+	case 'x', 't', 'd':
+		b, err := d.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		u := d.order.Uint64(b)
+		switch signature[0] {
+		case 'x':
+			return int64(u), nil
+		case 'd':
+			return math.Float64frombits(u), nil
+		}
+		return u, nil
+	case 's', 'o':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.read(int(d.order.Uint32(b)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		if signature[0] == 'o' {
+			return dbusObjectPath(s[:len(s)-1]), nil
+		}
+		return string(s[:len(s)-1]), nil
+	case 'g':
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.read(int(b[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return dbusSignature(s[:len(s)-1]), nil
+	case 'v':
+		s, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		signature := string(s.(dbusSignature))
+		if types, err := splitDBusSignature(signature); err != nil || len(types) != 1 {
+			return nil, fmt.Errorf("invalid D-Bus variant signature %q", signature)
+		}
+		value, err := d.decode(signature)
+		if err != nil {
+			return nil, err
+		}
+		return dbusVariant{signature, value}, nil
+	case 'a':
+		element := signature[1:]
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		length := int(d.order.Uint32(b))
+		if err := d.align(dbusAlignment(element[0])); err != nil {
+			return nil, err
+		}
+		if length > len(d.data)-d.pos {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if element == "y" {
+			data, _ := d.read(length)
+			return append([]byte{}, data...), nil
+		}
+		end := d.pos + length
+		items := []interface{}{}
+		for d.pos < end {
+			item, err := d.decode(element)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		types, err := splitDBusSignature(signature[1 : len(signature)-1])
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		var fields []interface{}
+		for _, t := range types {
+			field, err := d.decode(t)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("cannot read D-Bus type %s", signature)
+}
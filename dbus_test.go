@@ -0,0 +1,217 @@
+package main
+
+// dbus_test.go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeItem is an item in the keyring of fakeSecretService
+type fakeItem struct {
+	attributes map[string]string
+	secret     string
+	locked     bool
+}
+
+// fakeSecretService points DBUS_SESSION_BUS_ADDRESS at a bus serving a Secret Service with items,
+// and returns the method calls it answered as "Member path"
+func fakeSecretService(t *testing.T, items map[dbusObjectPath]fakeItem) (func() []string, func()) {
+	dir, err := ioutil.TempDir("", "snitchit-dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "bus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var calls []string
+	const session = dbusObjectPath("/org/freedesktop/secrets/session/1")
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		uid, _ := hex.DecodeString(strings.TrimSpace(strings.TrimPrefix(line, "\x00AUTH EXTERNAL ")))
+		if string(uid) != strconv.Itoa(os.Getuid()) {
+			conn.Write([]byte("REJECTED EXTERNAL\r\n"))
+			return
+		}
+		conn.Write([]byte("OK 0123456789abcdef0123456789abcdef\r\n"))
+		if line, _ := reader.ReadString('\n'); line != "BEGIN\r\n" {
+			return
+		}
+
+		serial := uint32(0)
+		send := func(m dbusMessage) {
+			serial++
+			m.Serial = serial
+			data, err := m.marshal()
+			if err != nil {
+				t.Errorf("fake bus cannot marshal %+v: %s", m, err)
+				return
+			}
+			conn.Write(data)
+		}
+		for {
+			call, err := readDBusMessage(reader)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			calls = append(calls, call.Member+" "+string(call.Path))
+			mu.Unlock()
+
+			reply := dbusMessage{Type: dbusMethodReturn, ReplySerial: call.Serial}
+			switch {
+			case call.Member == "Hello":
+				// the bus announces the name it gave before replying
+				send(dbusMessage{Type: dbusSignal, Path: "/org/freedesktop/DBus", Interface: "org.freedesktop.DBus", Member: "NameAcquired", Signature: "s", Body: []interface{}{":1.7"}})
+				reply.Signature, reply.Body = "s", []interface{}{":1.7"}
+			case call.Member == "OpenSession" && call.Signature == "sv" && call.Body[0] == "plain":
+				reply.Signature, reply.Body = "vo", []interface{}{dbusVariant{"s", ""}, session}
+			case call.Member == "SearchItems" && call.Signature == "a{ss}":
+				wanted := make(map[string]string)
+				for _, entry := range call.Body[0].([]interface{}) {
+					wanted[entry.([]interface{})[0].(string)] = entry.([]interface{})[1].(string)
+				}
+				unlocked, locked := []interface{}{}, []interface{}{}
+				for path, item := range items {
+					if reflect.DeepEqual(item.attributes, wanted) {
+						if item.locked {
+							locked = append(locked, path)
+						} else {
+							unlocked = append(unlocked, path)
+						}
+					}
+				}
+				reply.Signature, reply.Body = "aoao", []interface{}{unlocked, locked}
+			case call.Member == "GetSecret" && call.Signature == "o" && call.Body[0] == session:
+				item, ok := items[call.Path]
+				if !ok || item.locked {
+					reply = dbusMessage{Type: dbusErrorReply, ReplySerial: call.Serial, ErrorName: "org.freedesktop.Secret.Error.IsLocked", Signature: "s", Body: []interface{}{"locked"}}
+					break
+				}
+				reply.Signature, reply.Body = "(oayays)", []interface{}{[]interface{}{session, []byte{}, []byte(item.secret), "text/plain"}}
+			case call.Member == "Close" && call.Path == session:
+			default:
+				reply = dbusMessage{Type: dbusErrorReply, ReplySerial: call.Serial, ErrorName: "org.freedesktop.DBus.Error.UnknownMethod", Signature: "s", Body: []interface{}{"no method " + call.Member}}
+			}
+			send(reply)
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	previous, set := os.LookupEnv("DBUS_SESSION_BUS_ADDRESS")
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(dir, "bus")+",guid=0123456789abcdef0123456789abcdef")
+	return func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), calls...)
+		}, func() {
+			if set {
+				os.Setenv("DBUS_SESSION_BUS_ADDRESS", previous)
+			} else {
+				os.Unsetenv("DBUS_SESSION_BUS_ADDRESS")
+			}
+			listener.Close()
+			os.RemoveAll(dir)
+		}
+}
+
+func TestDBusMessage(t *testing.T) {
+	tests := []struct {
+		signature string
+		sent      []interface{}
+		received  []interface{}
+	}{
+		{"", nil, nil},
+		{"s", []interface{}{"hello"}, []interface{}{"hello"}},
+		{"yubis", []interface{}{byte(7), uint32(1 << 31), true, int32(-2), "x"}, []interface{}{byte(7), uint32(1 << 31), true, int32(-2), "x"}},
+		{"sv", []interface{}{"plain", dbusVariant{"s", ""}}, []interface{}{"plain", dbusVariant{"s", ""}}},
+		{"a{ss}", []interface{}{map[string]string{"service": "snitchit", "account": "dms"}}, []interface{}{[]interface{}{[]interface{}{"account", "dms"}, []interface{}{"service", "snitchit"}}}},
+		{"aoao", []interface{}{[]interface{}{dbusObjectPath("/a"), dbusObjectPath("/b")}, []interface{}{}}, []interface{}{[]interface{}{dbusObjectPath("/a"), dbusObjectPath("/b")}, []interface{}{}}},
+		{"y(oayays)", []interface{}{byte(1), []interface{}{dbusObjectPath("/s"), []byte{}, []byte("key"), "text/plain"}}, []interface{}{byte(1), []interface{}{dbusObjectPath("/s"), []byte{}, []byte("key"), "text/plain"}}},
+		{"as", []interface{}{[]string{"a", "bc"}}, []interface{}{[]interface{}{"a", "bc"}}},
+	}
+	for _, test := range tests {
+		sent := dbusMessage{Type: dbusMethodCall, Serial: 9, Path: "/org/freedesktop/secrets", Member: "Test", Destination: "org.freedesktop.secrets", Signature: test.signature, Body: test.sent}
+		data, err := sent.marshal()
+		if err != nil {
+			t.Errorf("marshal %s = %v", test.signature, err)
+			continue
+		}
+		if len(data)%8 != 0 && test.signature == "" {
+			t.Errorf("message without a body is %d bytes, want the header padded to 8", len(data))
+		}
+		received, err := readDBusMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("read %s = %v", test.signature, err)
+			continue
+		}
+		if received.Serial != 9 || received.Path != sent.Path || received.Member != "Test" || received.Destination != sent.Destination || received.Signature != test.signature || !reflect.DeepEqual(received.Body, test.received) {
+			t.Errorf("%s sent %+v, received %+v", test.signature, sent, received)
+		}
+	}
+
+	// values which do not fit the signature are refused rather than sent
+	for _, bad := range []dbusMessage{
+		{Signature: "s", Body: []interface{}{1}},
+		{Signature: "ss", Body: []interface{}{"one"}},
+		{Signature: "a{ss", Body: []interface{}{map[string]string{}}},
+	} {
+		if _, err := bad.marshal(); err == nil {
+			t.Errorf("marshal %s %v succeeded", bad.Signature, bad.Body)
+		}
+	}
+
+	// a truncated message is an error, not a panic
+	data, _ := dbusMessage{Type: dbusMethodReturn, Serial: 1, ReplySerial: 1, Signature: "s", Body: []interface{}{"hello"}}.marshal()
+	if _, err := readDBusMessage(bytes.NewReader(data[:len(data)-3])); err == nil {
+		t.Errorf("read of a truncated message succeeded")
+	}
+}
+
+func TestDialDBus(t *testing.T) {
+	calls, cleanup := fakeSecretService(t, nil)
+	defer cleanup()
+
+	for _, address := range []string{"", "tcp:host=localhost,port=1", "unix:guid=abc"} {
+		if _, err := dialDBus(address); err == nil {
+			t.Errorf("dialDBus(%q) succeeded", address)
+		}
+	}
+
+	// the first address which answers is used
+	bus, err := dialDBus("unix:path=/nonexistent/bus;" + os.Getenv("DBUS_SESSION_BUS_ADDRESS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.close()
+	if _, err := bus.call("org.freedesktop.secrets", "/", "org.example", "Missing", ""); err == nil || err.Error() != "org.freedesktop.DBus.Error.UnknownMethod: no method Missing" {
+		t.Errorf("calling a missing method = %v, want the error from the bus", err)
+	}
+	if got, want := calls(), []string{"Hello /org/freedesktop/DBus", "Missing /"}; !equalStrings(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+// due.go
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+// periodStart returns the start of the snitch interval period containing t, periods are aligned in UTC
+func periodStart(interval string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch strings.ToLower(interval) {
+	case "15_minute":
+		return t.Truncate(15 * time.Minute)
+	case "30_minute":
+		return t.Truncate(30 * time.Minute)
+	case "hourly":
+		return t.Truncate(time.Hour)
+	case "daily":
+		return day
+	case "weekly":
+		// weeks start on monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "monthly":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// jobDue reports whether the snitch has no successful check in for the current period yet.
+// It asks deadmanssnitch.com when an API key is set, otherwise it uses the last successful check in sent from this host
+// and --interval. When neither tells it anything the job is due.
+func jobDue(token string) (bool, string) {
+	if apikey != "" {
+		found, err := getSnitch(token)
+		if err == nil {
+			start := periodStart(found.Interval, time.Now())
+			switch {
+			case found.Status == "errored":
+				return true, "last check in was errored"
+			case found.CheckedInAt.IsZero() || found.CheckedInAt.Before(start):
+				return true, fmt.Sprintf("no check in since the %s period started at %s", found.Interval, start.Format(time.RFC3339))
+			default:
+				return false, fmt.Sprintf("checked in at %s, in the current %s period", found.CheckedInAt.Format(time.RFC3339), found.Interval)
+			}
+		}
+		if verbose {
+			fmt.Println("Due: cannot look up snitch, using local state:", err)
+		}
+	}
+
+	interval := viper.GetString("interval")
+	if interval == "" {
+		return true, "interval unknown, use --interval when offline"
+	}
+
+	last, ok := lastCheckIns()[token]
+	start := periodStart(interval, time.Now())
+	if !ok || last.Before(start) {
+		return true, fmt.Sprintf("no check in from this host since the %s period started at %s", interval, start.Format(time.RFC3339))
+	}
+	return false, fmt.Sprintf("checked in from this host at %s, in the current %s period", last.Format(time.RFC3339), interval)
+}
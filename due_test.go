@@ -0,0 +1,84 @@
+package main
+
+// due_test.go
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// a thursday
+	at := time.Date(2026, time.October, 15, 13, 47, 12, 0, time.UTC)
+
+	tests := []struct {
+		interval string
+		at       time.Time
+		want     time.Time
+	}{
+		{"15_minute", at, time.Date(2026, time.October, 15, 13, 45, 0, 0, time.UTC)},
+		{"30_minute", at, time.Date(2026, time.October, 15, 13, 30, 0, 0, time.UTC)},
+		{"hourly", at, time.Date(2026, time.October, 15, 13, 0, 0, 0, time.UTC)},
+		{"Daily", at, time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)},
+		{"weekly", at, time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC), time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2026, time.October, 18, 23, 59, 0, 0, time.UTC), time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC)},
+		{"monthly", at, time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		// periods are aligned in UTC whatever the zone of the time
+		{"daily", time.Date(2026, time.October, 16, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)},
+		{"yearly", at, time.Time{}},
+	}
+	for _, test := range tests {
+		if got := periodStart(test.interval, test.at); !got.Equal(test.want) {
+			t.Errorf("periodStart(%q, %s) = %s, want %s", test.interval, test.at, got, test.want)
+		}
+	}
+}
+
+func TestJobDue(t *testing.T) {
+	_, cleanupapi := fakeAPI(t, []oneSnitch{
+		{Token: "errored", Interval: "daily", Status: "errored", CheckedInAt: time.Now()},
+		{Token: "today", Interval: "daily", Status: "healthy", CheckedInAt: time.Now()},
+		{Token: "lastweek", Interval: "daily", Status: "healthy", CheckedInAt: time.Now().AddDate(0, 0, -7)},
+		{Token: "never", Interval: "hourly", Status: "pending"},
+	})
+	defer cleanupapi()
+	_, cleanupcheckins := fakeCheckIns(t)
+	defer cleanupcheckins()
+	defer func(k string) { apikey = k }(apikey)
+	defer viper.Set("interval", nil)
+	defer viper.Set("only-if-due", nil)
+
+	// with --only-if-due successful check ins are remembered for the offline check
+	viper.Set("only-if-due", true)
+	sendCheckIn("offline", "done", "")
+	sendCheckIn("offlinefailed", "failed", "1")
+
+	tests := []struct {
+		apikey   string
+		interval string
+		token    string
+		due      bool
+		reason   string
+	}{
+		{"aaa111", "", "errored", true, "last check in was errored"},
+		{"aaa111", "", "today", false, "in the current daily period"},
+		{"aaa111", "", "lastweek", true, "no check in since the daily period started"},
+		{"aaa111", "", "never", true, "no check in since the hourly period started"},
+		{"aaa111", "", "missing", true, "interval unknown"},
+		{"", "", "offline", true, "interval unknown"},
+		{"", "daily", "offline", false, "checked in from this host"},
+		{"", "daily", "offlinefailed", true, "no check in from this host"},
+		{"aaa111", "daily", "offline", false, "checked in from this host"},
+	}
+	for _, test := range tests {
+		apikey = test.apikey
+		viper.Set("interval", test.interval)
+		due, reason := jobDue(test.token)
+		if due != test.due || !strings.Contains(reason, test.reason) {
+			t.Errorf("jobDue(%q) with apikey %q and interval %q = %v, %q, want %v, %q", test.token, test.apikey, test.interval, due, reason, test.due, test.reason)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+// heartbeat.go
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// healthCondition gates a heartbeat, check returns an error once the guarded thing is unhealthy
+type healthCondition struct {
+	name  string
+	check func() error
+}
+
+func heartbeat() {
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	runHeartbeat(heartbeatConditions(), func() (string, error) {
+		now := time.Now().Format(time.RFC3339)
+		msg := customMessage(messageFacts{Snitch: snitch, Message: now})
+		if msg == "" {
+			msg = now
+		}
+		return msg, nil
+	})
+}
+
+// runHeartbeat checks in every --every until signalled, exiting as soon as a condition fails.
+// beat is called before each check in, the check in is skipped when it returns an error.
+func runHeartbeat(conditions []healthCondition, beat func() (string, error)) {
+	every := viper.GetDuration("every")
+	if every <= 0 {
+		fmt.Println("ERROR: --every must be set, for example --every 10m")
+		os.Exit(1)
+	}
+
+	splay := viper.GetDuration("splay")
+	checkevery := viper.GetDuration("check-every")
+	if checkevery <= 0 {
+		checkevery = 30 * time.Second
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	rand.Seed(time.Now().UnixNano())
+
+	// spread a fleet of heartbeats started together across the splay window
+	next := time.NewTimer(jitter(splay))
+	defer next.Stop()
+
+	var checks <-chan time.Time
+	if len(conditions) != 0 {
+		ticker := time.NewTicker(checkevery)
+		defer ticker.Stop()
+		checks = ticker.C
+	}
+
+	if !silent {
+		fmt.Printf("Heartbeat for %s every %s (splay %s) with %d conditions\n", snitch, every, splay, len(conditions))
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if !silent {
+				fmt.Println("Heartbeat stopping on", sig)
+			}
+			return
+
+		case <-checks:
+			if err := checkConditions(conditions); err != nil {
+				fmt.Println("ERROR: Heartbeat stopped,", err)
+				os.Exit(1)
+			}
+
+		case <-next.C:
+			if err := checkConditions(conditions); err != nil {
+				fmt.Println("ERROR: Heartbeat stopped,", err)
+				os.Exit(1)
+			}
+
+			msg, err := beat()
+			if err != nil {
+				if !silent {
+					fmt.Println("Heartbeat: not checking in,", err)
+				}
+			} else if err := sendCheckIn(snitch, msg, ""); err != nil {
+				// keep going, the next beat may get through
+				log.Println("ERROR: Heartbeat check in failed:", err)
+			} else if verbose {
+				fmt.Println("Heartbeat: checked in", snitch, msg)
+			}
+
+			next.Reset(every + jitter(splay))
+		}
+	}
+}
+
+func jitter(splay time.Duration) time.Duration {
+	if splay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(splay)))
+}
+
+func checkConditions(conditions []healthCondition) error {
+	for _, condition := range conditions {
+		if err := condition.check(); err != nil {
+			return fmt.Errorf("%s: %s", condition.name, err)
+		}
+		if verbose {
+			fmt.Println("Heartbeat: healthy", condition.name)
+		}
+	}
+	return nil
+}
+
+func heartbeatConditions() []healthCondition {
+	var conditions []healthCondition
+
+	timeout := viper.GetDuration("timeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	if pid := viper.GetInt("pid"); pid != 0 {
+		conditions = append(conditions, healthCondition{
+			name:  fmt.Sprintf("pid %d", pid),
+			check: func() error { return pidAlive(pid) },
+		})
+	}
+
+	if pidfile := viper.GetString("pidfile"); pidfile != "" {
+		conditions = append(conditions, healthCondition{
+			name: "pidfile " + pidfile,
+			check: func() error {
+				// re-read every time so a restarted service is followed
+				data, err := ioutil.ReadFile(pidfile)
+				if err != nil {
+					return err
+				}
+				pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+				if err != nil {
+					return fmt.Errorf("invalid pid in file")
+				}
+				return pidAlive(pid)
+			},
+		})
+	}
+
+	if healthcmd := viper.GetString("health-cmd"); healthcmd != "" {
+		conditions = append(conditions, healthCondition{
+			name: "health command",
+			check: func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				output, err := exec.CommandContext(ctx, "/bin/sh", "-c", healthcmd).CombinedOutput()
+				if ctx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf("timed out after %s", timeout)
+				}
+				if err != nil {
+					return fmt.Errorf("%s %s", err, strings.TrimSpace(string(output)))
+				}
+				return nil
+			},
+		})
+	}
+
+	if healthurl := viper.GetString("health-url"); healthurl != "" {
+		conditions = append(conditions, healthCondition{
+			name: "health url " + healthurl,
+			check: func() error {
+				client := &http.Client{}
+				client.Timeout = timeout
+				resp, err := client.Get(healthurl)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				ioutil.ReadAll(resp.Body)
+				if resp.StatusCode < 200 || resp.StatusCode > 299 {
+					return fmt.Errorf("returned %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+				}
+				return nil
+			},
+		})
+	}
+
+	return conditions
+}
+
+func pidAlive(pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	err := syscall.Kill(pid, 0)
+	if err == nil || err == syscall.EPERM {
+		// EPERM means the process exists but belongs to someone else
+		return nil
+	}
+	return fmt.Errorf("process %d not running", pid)
+}
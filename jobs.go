@@ -0,0 +1,457 @@
+package main
+
+// jobs.go
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// job is a command wrapped by snitchit, either from the jobs section of the config or the command line
+type job struct {
+	Name     string        `mapstructure:"-"`
+	Command  string        `mapstructure:"command"`
+	Args     []string      `mapstructure:"-"`
+	Snitch   string        `mapstructure:"snitch"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Env      []string      `mapstructure:"env"`
+	Dir      string        `mapstructure:"dir"`
+	Schedule string        `mapstructure:"schedule"`
+
+	MaxDuration time.Duration `mapstructure:"max-duration"`
+	MinDuration time.Duration `mapstructure:"min-duration"`
+
+	Lock     string        `mapstructure:"lock"`
+	LockWait time.Duration `mapstructure:"lock-wait"`
+	LeaseDir string        `mapstructure:"lease-dir"`
+	LeaseTTL time.Duration `mapstructure:"lease-ttl"`
+}
+
+type jobResult struct {
+	Name     string
+	ExitCode int
+	Started  time.Time
+	Duration time.Duration
+	TimedOut bool
+	Err      error    // set when the job could not be started or timed out
+	Output   []string // last lines of output, redacted
+	LogFile  string
+	Problem  string // set when a successful run took longer or shorter than expected
+	Skipped  string // set when the job was not run
+
+	UserCPU time.Duration
+	SysCPU  time.Duration
+	MaxRSS  int64 // kilobytes
+}
+
+func loadJobs() map[string]job {
+	jobs := make(map[string]job)
+	if err := viper.UnmarshalKey("jobs", &jobs); err != nil {
+		fmt.Println("ERROR: Cannot read jobs from config:", err)
+		os.Exit(1)
+	}
+	for name, j := range jobs {
+		j.Name = name
+		j.Snitch = resolveSnitch(j.Snitch)
+		jobs[name] = j
+	}
+	return jobs
+}
+
+// runCommand handles "snitchit run <job>" and "snitchit run --snitch [snitch] -- command args"
+func runCommand() {
+	var j job
+
+	if pflag.CommandLine.ArgsLenAtDash() >= 0 {
+		args := pflag.Args()[pflag.CommandLine.ArgsLenAtDash():]
+		if len(args) == 0 {
+			fmt.Println("ERROR: No command given after --")
+			os.Exit(1)
+		}
+		// name ad hoc jobs after the program they run
+		program := args[0]
+		if fields := strings.Fields(program); len(fields) != 0 {
+			program = fields[0]
+		}
+		j = job{
+			Name:    filepath.Base(program),
+			Snitch:  snitch,
+			Timeout: viper.GetDuration("timeout"),
+		}
+		if len(args) == 1 {
+			j.Command = args[0]
+		} else {
+			j.Args = args
+		}
+	} else {
+		name := pflag.Arg(1)
+		if name == "" {
+			fmt.Println("ERROR: No job given, use \"snitchit run <job>\" or \"snitchit run --snitch [snitch] -- command\"")
+			os.Exit(1)
+		}
+		jobs := loadJobs()
+		var ok bool
+		if j, ok = jobs[strings.ToLower(name)]; !ok {
+			fmt.Println("ERROR: No job named", name, "in config")
+			os.Exit(1)
+		}
+		if viper.GetString("snitch") != "" {
+			j.Snitch = resolveSnitch(viper.GetString("snitch"))
+		}
+	}
+
+	if viper.GetDuration("max-duration") > 0 {
+		j.MaxDuration = viper.GetDuration("max-duration")
+	}
+	if viper.GetDuration("min-duration") > 0 {
+		j.MinDuration = viper.GetDuration("min-duration")
+	}
+	if viper.GetString("lock") != "" {
+		j.Lock = viper.GetString("lock")
+	}
+	if viper.GetDuration("lock-wait") > 0 {
+		j.LockWait = viper.GetDuration("lock-wait")
+	}
+	if viper.GetString("lease-dir") != "" {
+		j.LeaseDir = viper.GetString("lease-dir")
+	}
+	if viper.GetDuration("lease-ttl") > 0 {
+		j.LeaseTTL = viper.GetDuration("lease-ttl")
+	}
+
+	if j.Snitch == "" {
+		fmt.Println("ERROR: No snitch defined for job", j.Name)
+		os.Exit(1)
+	}
+
+	if viper.GetBool("only-if-due") {
+		due, reason := jobDue(j.Snitch)
+		if !due {
+			if !silent {
+				fmt.Println("Job:", j.Name, "not due,", reason)
+			}
+			os.Exit(0)
+		}
+		if verbose {
+			fmt.Println("Job:", j.Name, "due,", reason)
+		}
+	}
+
+	result := runJob(j)
+	os.Exit(result.ExitCode)
+}
+
+// runJob runs the job to completion and checks in with its exit status
+func runJob(j job) jobResult {
+	lock, err := lockJob(j)
+	if err != nil {
+		skipped := jobResult{Name: j.Name, Started: time.Now(), Skipped: err.Error()}
+		if !silent {
+			fmt.Println("Job:", j.Name, "not run,", err)
+		}
+		if _, ok := err.(leaseHeldError); ok {
+			// another host is running the job and will check in
+			return skipped
+		}
+		skipped.ExitCode = 1
+		if err := sendCheckIn(j.Snitch, jobMessage(j, skipped), "1"); err != nil {
+			log.Println("ERROR: Check in for job", j.Name, "failed:", err)
+		}
+		return skipped
+	}
+	defer lock.release()
+
+	result := executeJob(j)
+
+	// a run which succeeds far too slowly or quickly still needs looking at
+	status := strconv.Itoa(result.ExitCode)
+	if result.ExitCode == 0 && result.Err == nil {
+		switch {
+		case j.MaxDuration > 0 && result.Duration > j.MaxDuration:
+			result.Problem = fmt.Sprintf("longer than max duration %s", j.MaxDuration)
+		case j.MinDuration > 0 && result.Duration < j.MinDuration:
+			result.Problem = fmt.Sprintf("shorter than min duration %s", j.MinDuration)
+		}
+		if result.Problem != "" {
+			status = "1"
+		}
+	}
+
+	recordJob(result)
+
+	if err := sendCheckIn(j.Snitch, jobMessage(j, result), status); err != nil {
+		log.Println("ERROR: Check in for job", j.Name, "failed:", err)
+	} else if verbose {
+		fmt.Println("Job:", j.Name, "checked in to", j.Snitch)
+	}
+
+	return result
+}
+
+func executeJob(j job) jobResult {
+	var cmd *exec.Cmd
+	if len(j.Args) != 0 {
+		cmd = exec.Command(j.Args[0], j.Args[1:]...)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", j.Command)
+	}
+
+	cmd.Dir = j.Dir
+	cmd.Env = append(os.Environ(), j.Env...)
+	output := newJobOutput(j.Name)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, output)
+
+	// run in its own process group so a timeout kills everything the job started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	result := jobResult{Name: j.Name, Started: time.Now()}
+
+	if verbose {
+		fmt.Println("Job:", j.Name, "starting:", j.Command, strings.Join(j.Args, " "))
+	}
+
+	if err := cmd.Start(); err != nil {
+		result.ExitCode = 127
+		result.Err = err
+		output.close()
+		return result
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timeout <-chan time.Time
+	if j.Timeout > 0 {
+		timer := time.NewTimer(j.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case sig := <-signals:
+			// pass interrupts on to the job and let it decide how to exit
+			syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+		case <-timeout:
+			result.TimedOut = true
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+
+	result.Duration = time.Since(result.Started)
+	result.ExitCode = cmd.ProcessState.ExitCode()
+
+	if result.TimedOut {
+		result.ExitCode = 124
+		result.Err = fmt.Errorf("timed out after %s", j.Timeout)
+	} else if result.ExitCode < 0 {
+		// killed by a signal, report it the way a shell would
+		result.ExitCode = 128 + int(cmd.ProcessState.Sys().(syscall.WaitStatus).Signal())
+	}
+
+	if usage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		result.UserCPU = time.Duration(usage.Utime.Nano())
+		result.SysCPU = time.Duration(usage.Stime.Nano())
+		// maxrss is kilobytes on linux but bytes on darwin
+		result.MaxRSS = int64(usage.Maxrss)
+		if runtime.GOOS == "darwin" {
+			result.MaxRSS /= 1024
+		}
+	}
+
+	result.LogFile = output.close()
+	result.Output = output.lines(viper.GetInt("output-lines"))
+
+	return result
+}
+
+func jobMessage(j job, result jobResult) string {
+	duration := result.Duration.Round(time.Second)
+	if result.Duration < time.Second {
+		duration = result.Duration.Round(time.Millisecond)
+	}
+
+	var msg string
+	switch {
+	case result.Skipped != "":
+		msg = fmt.Sprintf("%s skipped, %s", result.Name, result.Skipped)
+	case result.TimedOut:
+		msg = fmt.Sprintf("%s timed out after %s", result.Name, duration)
+	case result.Err != nil:
+		msg = fmt.Sprintf("%s failed to start: %s", result.Name, result.Err)
+	default:
+		msg = fmt.Sprintf("%s exited %d after %s", result.Name, result.ExitCode, duration)
+	}
+
+	if result.Problem != "" {
+		msg = msg + ", " + result.Problem
+	}
+
+	if result.ExitCode != 0 && result.Skipped == "" {
+		msg = msg + failureOutput(result, maxMessageLength-len(msg))
+	}
+
+	custom := customMessage(messageFacts{
+		Snitch:   j.Snitch,
+		Job:      result.Name,
+		ExitCode: result.ExitCode,
+		Duration: duration.String(),
+		Output:   strings.Join(result.Output, "\n"),
+		LogFile:  result.LogFile,
+		Message:  msg,
+	})
+	if custom != "" {
+		return custom
+	}
+	return msg
+}
+
+// failureOutput points at the log and adds as many of the last lines of output as fit in to space
+func failureOutput(result jobResult, space int) string {
+	text := ""
+	if result.LogFile != "" {
+		text = ", log " + result.LogFile
+	}
+
+	var lines []string
+	used := len(text) + 2
+	for i := len(result.Output) - 1; i >= 0; i-- {
+		if used+len(result.Output[i])+3 > space {
+			break
+		}
+		used += len(result.Output[i]) + 3
+		lines = append([]string{result.Output[i]}, lines...)
+	}
+	if len(lines) != 0 {
+		text = text + ": " + strings.Join(lines, " | ")
+	}
+	return text
+}
+
+// scheduler runs every job with a schedule as a long lived replacement for crontab
+func scheduler() {
+	jobs := loadJobs()
+
+	schedules := make(map[string]*cronSchedule)
+	var names []string
+	for name, j := range jobs {
+		if j.Schedule == "" {
+			continue
+		}
+		if j.Snitch == "" {
+			fmt.Println("ERROR: No snitch defined for job", name)
+			os.Exit(1)
+		}
+		schedule, err := parseCron(j.Schedule)
+		if err != nil {
+			fmt.Println("ERROR: Invalid schedule for job", name, ":", err)
+			os.Exit(1)
+		}
+		schedules[name] = schedule
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		fmt.Println("ERROR: No jobs with a schedule defined in config")
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	var wg sync.WaitGroup
+	var runningmu sync.Mutex
+	running := make(map[string]bool)
+
+	nextrun := make(map[string]time.Time)
+	for _, name := range names {
+		nextrun[name] = schedules[name].next(time.Now())
+		if !silent {
+			fmt.Printf("Scheduled %s, next run %s\n", name, nextrun[name].Format(time.RFC3339))
+		}
+	}
+
+	for {
+		var soonest time.Time
+		for _, name := range names {
+			if !nextrun[name].IsZero() && (soonest.IsZero() || nextrun[name].Before(soonest)) {
+				soonest = nextrun[name]
+			}
+		}
+		if soonest.IsZero() {
+			fmt.Println("ERROR: No job will ever run again")
+			os.Exit(1)
+		}
+
+		timer := time.NewTimer(time.Until(soonest))
+		select {
+		case sig := <-signals:
+			timer.Stop()
+			if !silent {
+				fmt.Println("Scheduler stopping on", sig, ", waiting for running jobs")
+			}
+			wg.Wait()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for _, name := range names {
+			if nextrun[name].IsZero() || nextrun[name].After(now) {
+				continue
+			}
+			nextrun[name] = schedules[name].next(now)
+
+			j := jobs[name]
+
+			runningmu.Lock()
+			overlap := running[name]
+			running[name] = true
+			runningmu.Unlock()
+
+			if overlap {
+				// never run two copies, and make the overlap visible on the snitch
+				log.Println("ERROR: Job", name, "still running, skipping this run")
+				if err := sendCheckIn(j.Snitch, name+" skipped, previous run still running", "1"); err != nil {
+					log.Println("ERROR: Check in for job", name, "failed:", err)
+				}
+				continue
+			}
+
+			wg.Add(1)
+			go func(j job) {
+				defer wg.Done()
+				result := runJob(j)
+				if !silent {
+					fmt.Println("Job:", jobMessage(j, result))
+				}
+				runningmu.Lock()
+				running[j.Name] = false
+				runningmu.Unlock()
+			}(j)
+		}
+	}
+}
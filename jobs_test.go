@@ -0,0 +1,50 @@
+package main
+
+// jobs_test.go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+
+	tests := []struct {
+		job      job
+		exitcode int
+		checkin  string
+	}{
+		{job{Name: "backup", Command: "true"}, 0, "abc [0] backup exited 0 after "},
+		{job{Name: "backup", Command: "exit 3"}, 3, "abc [3] backup exited 3 after "},
+		{job{Name: "backup", Command: "kill -TERM $$"}, 143, "abc [143] backup exited 143 after "},
+		{job{Name: "backup", Args: []string{"/nonexistent/backup"}}, 127, "abc [127] backup failed to start: "},
+		{job{Name: "backup", Command: "exec sleep 5", Timeout: 100 * time.Millisecond}, 124, "abc [124] backup timed out after "},
+		{job{Name: "backup", Command: `test "$TARGET" = offsite && test "$(pwd)" = "` + dir + `"`, Env: []string{"TARGET=offsite"}, Dir: dir}, 0, "abc [0] backup exited 0 after "},
+	}
+	for i, test := range tests {
+		test.job.Snitch = "abc"
+		result := runJob(test.job)
+		if result.ExitCode != test.exitcode {
+			t.Errorf("runJob(%q %q) exited %d, want %d", test.job.Command, test.job.Args, result.ExitCode, test.exitcode)
+		}
+		checkins := received()
+		if len(checkins) != i+1 {
+			t.Fatalf("runJob(%q %q) made %d check ins, want 1", test.job.Command, test.job.Args, len(checkins)-i)
+		}
+		if !strings.HasPrefix(checkins[i], test.checkin) {
+			t.Errorf("runJob(%q %q) checked in %q, want %q", test.job.Command, test.job.Args, checkins[i], test.checkin)
+		}
+	}
+}
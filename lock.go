@@ -0,0 +1,175 @@
+package main
+
+// lock.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// jobLock is held for the length of a run, by a local lock file, a lease in a shared directory, or both
+type jobLock struct {
+	file   *os.File
+	lease  string
+	holder string
+	stop   chan bool
+	done   chan bool
+}
+
+// jobLease is the content of a lease file
+type jobLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaseGrace is how long a released lease is kept, so hosts running the same schedule a little later do not run the job again
+const leaseGrace = time.Minute
+
+// leaseHeldError means another host holds the lease, so that host runs and checks in instead
+type leaseHeldError struct {
+	holder string
+}
+
+func (e leaseHeldError) Error() string {
+	return "lease held by " + e.holder
+}
+
+// lockJob takes the lock file and lease for the job, waiting up to LockWait for the lock file
+func lockJob(j job) (*jobLock, error) {
+	lock := &jobLock{}
+
+	if j.Lock != "" {
+		file, err := os.OpenFile(j.Lock, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open lock %s: %s", j.Lock, err)
+		}
+		deadline := time.Now().Add(j.LockWait)
+		for {
+			err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+			if err == nil {
+				break
+			}
+			if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+				file.Close()
+				if err == syscall.EWOULDBLOCK {
+					return nil, fmt.Errorf("previous run still running, lock %s held", j.Lock)
+				}
+				return nil, fmt.Errorf("cannot lock %s: %s", j.Lock, err)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		file.Truncate(0)
+		fmt.Fprintf(file, "%d\n", os.Getpid())
+		lock.file = file
+	}
+
+	if j.LeaseDir != "" {
+		if err := lock.takeLease(filepath.Join(j.LeaseDir, safeFileName(j.Name)+".lease"), j.LeaseTTL); err != nil {
+			lock.release()
+			return nil, err
+		}
+	}
+
+	return lock, nil
+}
+
+// takeLease claims the lease unless another host holds an unexpired one, then renews it until released
+func (l *jobLock) takeLease(path string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), rand.New(rand.NewSource(time.Now().UnixNano())).Int63())
+
+	if current, err := readLease(path); err == nil && time.Now().Before(current.Expires) {
+		return leaseHeldError{current.Holder}
+	}
+
+	if err := writeLease(path, jobLease{Holder: holder, Expires: time.Now().Add(ttl)}); err != nil {
+		return fmt.Errorf("cannot write lease %s: %s", path, err)
+	}
+
+	// hosts racing for an expired lease all write it, whoever wrote last wins
+	time.Sleep(time.Second)
+	current, err := readLease(path)
+	if err != nil {
+		return fmt.Errorf("cannot read lease %s: %s", path, err)
+	}
+	if current.Holder != holder {
+		return leaseHeldError{current.Holder}
+	}
+
+	l.lease = path
+	l.holder = holder
+	l.stop = make(chan bool)
+	l.done = make(chan bool)
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		defer close(l.done)
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				writeLease(path, jobLease{Holder: holder, Expires: time.Now().Add(ttl)})
+			}
+		}
+	}()
+
+	return nil
+}
+
+func readLease(path string) (jobLease, error) {
+	var lease jobLease
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lease, err
+	}
+	err = json.Unmarshal(data, &lease)
+	return lease, err
+}
+
+// writeLease replaces the lease in one rename so other hosts never read half a lease
+func writeLease(path string, lease jobLease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".lease-")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	temp.Close()
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// release unlocks the lock file, and shortens the lease to leaseGrace if this run still holds it
+func (l *jobLock) release() {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		if current, err := readLease(l.lease); err == nil && current.Holder == l.holder && time.Until(current.Expires) > leaseGrace {
+			writeLease(l.lease, jobLease{Holder: l.holder, Expires: time.Now().Add(leaseGrace)})
+		}
+	}
+	if l.file != nil {
+		syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+		l.file.Close()
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+// lock_test.go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockJobFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j := job{Name: "backup", Lock: filepath.Join(dir, "backup.lock"), LockWait: 200 * time.Millisecond}
+	lock, err := lockJob(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, _ := ioutil.ReadFile(j.Lock); strings.TrimSpace(string(pid)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file = %q, want the pid %d", pid, os.Getpid())
+	}
+
+	// an overlapping run waits for LockWait, then gives up
+	started := time.Now()
+	if _, err := lockJob(j); err == nil || !strings.Contains(err.Error(), "previous run still running") {
+		t.Errorf("second lockJob = %v, want the previous run still running", err)
+	}
+	if waited := time.Since(started); waited < j.LockWait {
+		t.Errorf("second lockJob gave up after %s, want at least %s", waited, j.LockWait)
+	}
+
+	lock.release()
+	again, err := lockJob(j)
+	if err != nil {
+		t.Fatalf("lockJob after release = %v", err)
+	}
+	again.release()
+}
+
+func TestLockJobLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j := job{Name: "backup", LeaseDir: dir, LeaseTTL: time.Minute}
+	path := filepath.Join(dir, "backup.lease")
+
+	// an unexpired lease from another host means that host runs the job
+	writeLease(path, jobLease{Holder: "other:1:1", Expires: time.Now().Add(time.Minute)})
+	if _, err := lockJob(j); err == nil {
+		t.Errorf("lockJob with the lease held succeeded")
+	} else if held, ok := err.(leaseHeldError); !ok || held.holder != "other:1:1" {
+		t.Errorf("lockJob with the lease held = %v, want it held by other:1:1", err)
+	}
+
+	// an expired lease is taken over
+	writeLease(path, jobLease{Holder: "other:1:1", Expires: time.Now().Add(-time.Second)})
+	lock, err := lockJob(j)
+	if err != nil {
+		t.Fatalf("lockJob with the lease expired = %v", err)
+	}
+	hostname, _ := os.Hostname()
+	current, err := readLease(path)
+	if err != nil || !strings.HasPrefix(current.Holder, hostname+":") || time.Until(current.Expires) < 50*time.Second {
+		t.Errorf("lease = %+v, %v, want it held by %s for a minute", current, err, hostname)
+	}
+
+	// a lease another host took over since is left alone
+	taken := jobLease{Holder: "other:2:2", Expires: time.Now().Add(time.Hour).Round(time.Second)}
+	writeLease(path, taken)
+	lock.release()
+	if after, err := readLease(path); err != nil || after.Holder != taken.Holder || !after.Expires.Equal(taken.Expires) {
+		t.Errorf("lease after release = %+v, %v, want %+v", after, err, taken)
+	}
+
+	// releasing shortens our own lease to the grace period
+	long, err := lockJob(job{Name: "long", LeaseDir: dir, LeaseTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long.release()
+	if released, err := readLease(filepath.Join(dir, "long.lease")); err != nil || !strings.HasPrefix(released.Holder, hostname+":") || time.Until(released.Expires) > leaseGrace {
+		t.Errorf("released lease = %+v, %v, want it to expire within %s", released, err, leaseGrace)
+	}
+}
+
+func TestRunJobLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+
+	locked := job{Name: "backup", Snitch: "abc", Command: "touch " + filepath.Join(dir, "ran"), Lock: filepath.Join(dir, "backup.lock")}
+	lock, err := lockJob(locked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.release()
+
+	// a run overlapping a local one fails its check in
+	if result := runJob(locked); result.Skipped == "" || result.ExitCode != 1 {
+		t.Errorf("runJob with the lock held = %+v, want it skipped", result)
+	}
+
+	// a run on a host without the lease leaves the check in to the holder
+	leased := job{Name: "backup", Snitch: "abc", Command: "touch " + filepath.Join(dir, "ran"), LeaseDir: dir}
+	writeLease(filepath.Join(dir, "backup.lease"), jobLease{Holder: "other:1:1", Expires: time.Now().Add(time.Minute)})
+	var result jobResult
+	shown := captureStdout(t, func() { result = runJob(leased) })
+	if result.Skipped == "" || result.ExitCode != 0 {
+		t.Errorf("runJob with the lease held = %+v, want it skipped", result)
+	}
+	if want := "Job: backup not run, lease held by other:1:1\n"; shown != want {
+		t.Errorf("runJob with the lease held printed %q, want %q", shown, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Errorf("a skipped job ran")
+	}
+	checkins := received()
+	if len(checkins) != 1 || !strings.HasPrefix(checkins[0], "abc [1] backup skipped, previous run still running, lock ") {
+		t.Errorf("check ins = %q, want one for the overlapping local run", checkins)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+// message.go
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// maxMessageLength is the longest check in message deadmanssnitch.com keeps
+const maxMessageLength = 250
+
+// messageFacts are the values message templates can use, host facts are methods so they are only looked up when used
+type messageFacts struct {
+	Snitch   string
+	Job      string
+	ExitCode int
+	Duration string
+	Output   string // last lines of job output
+	LogFile  string
+	Message  string // the message snitchit would send without a template
+}
+
+var messageTemplate *template.Template
+
+// loadMessageTemplate reads the template from --message-file, "-" being stdin, or --message
+func loadMessageTemplate() error {
+	text := viper.GetString("message")
+
+	if file := viper.GetString("message-file"); file != "" {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		text = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return err
+	}
+	messageTemplate = tmpl
+	return nil
+}
+
+// customMessage renders the message template, returning "" when none was given
+func customMessage(facts messageFacts) string {
+	if messageTemplate == nil {
+		return ""
+	}
+
+	var out bytes.Buffer
+	if err := messageTemplate.Execute(&out, facts); err != nil {
+		log.Println("ERROR: Cannot render message:", err)
+		return facts.Message
+	}
+	return out.String()
+}
+
+// truncateMessage shortens messages to maxMessageLength characters without splitting a character
+func truncateMessage(msg string) string {
+	if utf8.RuneCountInString(msg) <= maxMessageLength {
+		return msg
+	}
+	runes := []rune(msg)
+	return string(runes[:maxMessageLength-3]) + "..."
+}
+
+func (f messageFacts) Time() string {
+	return time.Now().Format(time.RFC3339)
+}
+
+func (f messageFacts) Hostname() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func (f messageFacts) FQDN() string {
+	hostname := f.Hostname()
+	if cname, err := net.LookupCNAME(hostname); err == nil && cname != "" {
+		return strings.TrimSuffix(cname, ".")
+	}
+	return hostname
+}
+
+// IP is the address of the interface used to reach the internet, no packets are sent
+func (f messageFacts) IP() string {
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func (f messageFacts) User() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+func (f messageFacts) PID() int {
+	return os.Getpid()
+}
+
+func (f messageFacts) Env(name string) string {
+	return os.Getenv(name)
+}
@@ -0,0 +1,105 @@
+package main
+
+// message_test.go
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMessageTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { messageTemplate = nil }()
+	defer viper.Set("message", nil)
+	defer viper.Set("message-file", nil)
+
+	messagefile := filepath.Join(dir, "message.tmpl")
+	ioutil.WriteFile(messagefile, []byte("{{.Job}} from a file\n"), 0600)
+	os.Setenv("SNITCHIT_TEST_TAG", "nightly")
+	defer os.Unsetenv("SNITCHIT_TEST_TAG")
+	hostname, _ := os.Hostname()
+
+	facts := messageFacts{Snitch: "abc", Job: "backup", ExitCode: 3, Duration: "2s", Message: "backup exited 3 after 2s"}
+	tests := []struct {
+		message     string
+		messagefile string
+		want        string
+		fails       bool
+	}{
+		{"", "", "", false},
+		{"done", "", "done", false},
+		{"{{.Job}} exited {{.ExitCode}} after {{.Duration}}", "", "backup exited 3 after 2s", false},
+		{"{{.Message}} on {{.Hostname}}", "", "backup exited 3 after 2s on " + hostname, false},
+		{`{{.Snitch}} {{.Env "SNITCHIT_TEST_TAG"}}`, "", "abc nightly", false},
+		{"ignored", messagefile, "backup from a file", false},
+		{"{{.Missing}}", "", "backup exited 3 after 2s", false},
+		{"{{.Job", "", "", true},
+		{"", filepath.Join(dir, "missing.tmpl"), "", true},
+	}
+	for _, test := range tests {
+		messageTemplate = nil
+		viper.Set("message", test.message)
+		viper.Set("message-file", test.messagefile)
+		err := loadMessageTemplate()
+		if test.fails {
+			if err == nil {
+				t.Errorf("loadMessageTemplate(%q, %q) succeeded, want an error", test.message, test.messagefile)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadMessageTemplate(%q, %q) = %v", test.message, test.messagefile, err)
+			continue
+		}
+		if got := customMessage(facts); got != test.want {
+			t.Errorf("customMessage with %q, %q = %q, want %q", test.message, test.messagefile, got, test.want)
+		}
+	}
+}
+
+func TestCheckInMessages(t *testing.T) {
+	defer func() { messageTemplate = nil }()
+	defer viper.Set("message", nil)
+	defer func(s string) { snitch = s }(snitch)
+	snitch = "abc"
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+
+	viper.Set("message", "{{.Job}} {{.ExitCode}}: {{.Message}}")
+	if err := loadMessageTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	runJob(job{Name: "backup", Command: "exit 2", Snitch: "abc"})
+
+	// the template replaces watch-file messages rather than being prefixed to them
+	viper.Set("message", "fresh backup")
+	if err := loadMessageTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(os.Args[0])
+	sendCheckIn(snitch, fileMessage("backup.tar.gz", info), "")
+
+	sendCheckIn(snitch, strings.Repeat("é", 300), "")
+
+	checkins := received()
+	if len(checkins) != 3 {
+		t.Fatalf("check ins = %q, want 3", checkins)
+	}
+	if want := "abc [2] backup 2: backup exited 2 after "; !strings.HasPrefix(checkins[0], want) {
+		t.Errorf("job check in = %q, want %q...", checkins[0], want)
+	}
+	if want := "abc fresh backup"; checkins[1] != want {
+		t.Errorf("watch-file check in = %q, want %q", checkins[1], want)
+	}
+	if want := "abc " + strings.Repeat("é", maxMessageLength-3) + "..."; checkins[2] != want {
+		t.Errorf("long check in = %q, want it truncated to %d characters", checkins[2], maxMessageLength)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+// output.go
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputTailBytes bounds how much job output is held in memory
+const outputTailBytes = 64 * 1024
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// defaultRedactions hide the most common ways secrets end up in job output
+var defaultRedactions = []string{
+	`(?i)((?:password|passwd|secret|token|api[_-]?key)\s*[=:]\s*)\S+`,
+	`(?i)(authorization:\s*\w+\s+)\S+`,
+	`(://[^:/@\s]+:)[^@/\s]+`,
+}
+
+// redactions are defaultRedactions and the redact regexes from the config, compiled by compileRedactions
+var redactions []*regexp.Regexp
+
+// jobOutput collects the combined stdout and stderr of a job, keeping the tail in memory and everything in a log file
+type jobOutput struct {
+	mu      sync.Mutex
+	tail    []byte
+	file    *os.File
+	partial []byte // the unfinished last line, logged once it ends so it can be redacted whole
+}
+
+// newJobOutput starts a log file for a run of the job under --log-dir, logging is skipped when the directory cannot be used
+func newJobOutput(name string) *jobOutput {
+	output := &jobOutput{}
+
+	dir := viper.GetString("log-dir")
+	if dir == "" {
+		var err error
+		if dir, err = stateDir("logs"); err != nil {
+			if verbose {
+				fmt.Println("Job: not logging output:", err)
+			}
+			return output
+		}
+	}
+	dir = filepath.Join(dir, safeFileName(name))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		if verbose {
+			fmt.Println("Job: not logging output:", err)
+		}
+		return output
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, time.Now().Format("20060102-150405.000")+".log"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		if verbose {
+			fmt.Println("Job: not logging output:", err)
+		}
+		return output
+	}
+	output.file = file
+
+	rotateLogs(dir, viper.GetInt("log-keep"))
+	return output
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file != nil {
+		o.partial = append(o.partial, p...)
+		if i := bytes.LastIndexByte(o.partial, '\n'); i >= 0 {
+			o.file.WriteString(redact(redactSecrets(string(o.partial[:i+1]))))
+			o.partial = append([]byte{}, o.partial[i+1:]...)
+		} else if len(o.partial) > outputTailBytes {
+			o.file.WriteString(redact(redactSecrets(string(o.partial))))
+			o.partial = nil
+		}
+	}
+
+	o.tail = append(o.tail, p...)
+	if len(o.tail) > outputTailBytes {
+		o.tail = append([]byte{}, o.tail[len(o.tail)-outputTailBytes:]...)
+	}
+	return len(p), nil
+}
+
+// close finishes the log file and returns its path
+func (o *jobOutput) close() string {
+	if o.file == nil {
+		return ""
+	}
+	o.file.WriteString(redact(redactSecrets(string(o.partial))))
+	o.partial = nil
+	o.file.Close()
+	return o.file.Name()
+}
+
+// lines returns up to the last n lines of output, without terminal escapes and with secrets redacted
+func (o *jobOutput) lines(n int) []string {
+	o.mu.Lock()
+	text := string(o.tail)
+	o.mu.Unlock()
+
+	text = redact(redactSecrets(ansiEscape.ReplaceAllString(text, "")))
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		// progress bars redraw with carriage returns, only the last draw matters
+		if i := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// compileRedactions compiles defaultRedactions and the redact regexes from the config, so a bad regex is found
+// before any output could leak through it
+func compileRedactions() error {
+	redactions = nil
+	for _, pattern := range append(defaultRedactions, viper.GetStringSlice("redact")...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("redact %q: %s", pattern, err)
+		}
+		redactions = append(redactions, re)
+	}
+	return nil
+}
+
+// redact replaces matches of the default and configured redact regexes with [REDACTED],
+// keeping the first capture group so the line still shows what was hidden
+func redact(text string) string {
+	for _, re := range redactions {
+		if re.NumSubexp() == 0 {
+			text = re.ReplaceAllString(text, "[REDACTED]")
+		} else {
+			text = re.ReplaceAllString(text, "${1}[REDACTED]")
+		}
+	}
+	return text
+}
+
+// rotateLogs keeps the newest keep logs in dir
+func rotateLogs(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var logs []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".log") {
+			logs = append(logs, file.Name())
+		}
+	}
+	sort.Strings(logs)
+	for len(logs) > keep {
+		os.Remove(filepath.Join(dir, logs[0]))
+		logs = logs[1:]
+	}
+}
+
+func safeFileName(name string) string {
+	return regexp.MustCompile(`[^A-Za-z0-9._-]+`).ReplaceAllString(name, "_")
+}
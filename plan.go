@@ -0,0 +1,226 @@
+package main
+
+// plan.go
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// planPolicy is what a deadmanssnitch.com plan allows, the alert types for each interval and how many snitches
+type planPolicy struct {
+	Description string
+	MaxSnitches int                 // 0 is unlimited
+	Alerts      map[string][]string // interval to the alert types allowed for it
+}
+
+var basicOnly = map[string][]string{
+	"15_minute": {"basic"},
+	"30_minute": {"basic"},
+	"hourly":    {"basic"},
+	"daily":     {"basic"},
+	"weekly":    {"basic"},
+	"monthly":   {"basic"},
+}
+
+// defaultPlans are the plans built in, any of which the plans section of the config can change or add to
+var defaultPlans = map[string]planPolicy{
+	"free": {
+		Description: "The Lone Snitch",
+		MaxSnitches: 1,
+		Alerts:      basicOnly,
+	},
+	"small": {
+		MaxSnitches: 100,
+		Alerts:      withSmart(basicOnly, "monthly"),
+	},
+	"medium": {
+		MaxSnitches: 500,
+		Alerts:      withSmart(basicOnly, "monthly"),
+	},
+	"large": {
+		Description: "Surveillance Van",
+		MaxSnitches: 1500,
+		Alerts:      withSmart(basicOnly, "hourly", "daily", "weekly", "monthly"),
+	},
+}
+
+func withSmart(alerts map[string][]string, intervals ...string) map[string][]string {
+	copied := make(map[string][]string)
+	for interval, types := range alerts {
+		copied[interval] = types
+	}
+	for _, interval := range intervals {
+		copied[interval] = append(append([]string{}, copied[interval]...), "smart")
+	}
+	return copied
+}
+
+// planPolicies returns the built in plans with the plans section of the config applied over them
+func planPolicies() map[string]planPolicy {
+	policies := make(map[string]planPolicy)
+	for name, policy := range defaultPlans {
+		policies[name] = policy
+	}
+
+	for name := range viper.GetStringMap("plans") {
+		name = strings.ToLower(name)
+		policy, ok := policies[name]
+		if !ok {
+			// added plans allow basic alerts unless they say otherwise
+			policy.Alerts = basicOnly
+		}
+		key := "plans." + name
+		if viper.IsSet(key + ".description") {
+			policy.Description = viper.GetString(key + ".description")
+		}
+		if viper.IsSet(key + ".max-snitches") {
+			policy.MaxSnitches = viper.GetInt(key + ".max-snitches")
+		}
+		if viper.IsSet(key + ".alerts") {
+			// intervals left out of the config keep the alerts they had
+			alerts := make(map[string][]string)
+			for interval, types := range policy.Alerts {
+				alerts[interval] = types
+			}
+			for interval := range viper.GetStringMap(key + ".alerts") {
+				alerts[strings.ToLower(interval)] = viper.GetStringSlice(key + ".alerts." + interval)
+			}
+			policy.Alerts = alerts
+		}
+		policies[name] = policy
+	}
+	return policies
+}
+
+// planNames returns the built in plans smallest first, then any the config adds
+func planNames() []string {
+	names := []string{"free", "small", "medium", "large"}
+	var added []string
+	for name := range planPolicies() {
+		if _, ok := defaultPlans[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	return append(names, added...)
+}
+
+// allows reports whether the plan allows alert for interval, an empty interval means any interval
+func (p planPolicy) allows(alert string, interval string) bool {
+	for i, types := range p.Alerts {
+		if interval != "" && !strings.EqualFold(i, interval) {
+			continue
+		}
+		for _, t := range types {
+			if strings.EqualFold(t, alert) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// planProblem explains why plan does not allow alert for interval
+func planProblem(plan string, alert string, interval string) string {
+	problem := fmt.Sprintf("the %s plan does not allow %s alerts", plan, alert)
+	if interval != "" {
+		problem += fmt.Sprintf(" for %s snitches", interval)
+	}
+	return problem
+}
+
+// planChosen reports whether plan was set by a config file, profile, environment variable or --plan, rather than left at its default
+func planChosen() bool {
+	return settingOrigin("plan") != "default"
+}
+
+// checkQuota returns an error when an account with count snitches cannot have another on plan.
+// plan defaults to free, so the snitch limit only applies once a plan has been chosen.
+func checkQuota(plan string, count int) error {
+	policy := planPolicies()[strings.ToLower(plan)]
+	if planChosen() && policy.MaxSnitches > 0 && count >= policy.MaxSnitches {
+		return fmt.Errorf("the %s plan allows %d snitches and the account has %d, see \"snitchit plan-info\"", plan, policy.MaxSnitches, count)
+	}
+	return nil
+}
+
+// enforcePlan exits when the plan does not allow alert for interval or, when adding a snitch, has no room for another
+func enforcePlan(alert string, interval string, adding bool) {
+	plan := strings.ToLower(viper.GetString("plan"))
+
+	if !checkPlan(plan, alert, interval) {
+		fmt.Println("ERROR:", planProblem(plan, alert, interval)+`, see "snitchit plan-info"`)
+		os.Exit(1)
+	}
+
+	if !adding {
+		return
+	}
+	snitches, err := listSnitches()
+	if err != nil {
+		fmt.Println("ERROR: Cannot count snitches:", err)
+		os.Exit(1)
+	}
+	if err := checkQuota(plan, len(snitches)); err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
+	}
+}
+
+// planInfo is snitchit plan-info, which explains what the plan allows and how many more snitches the account can have
+func planInfo() {
+	plan := strings.ToLower(viper.GetString("plan"))
+	policy, ok := planPolicies()[plan]
+	if !ok {
+		fmt.Println("ERROR: Unknown plan", plan)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Plan: %s", plan)
+	if policy.Description != "" {
+		fmt.Printf(" (%s)", policy.Description)
+	}
+	fmt.Println()
+
+	snitches, err := listSnitches()
+	switch {
+	case err != nil:
+		fmt.Println("Snitches: cannot count,", err)
+	case policy.MaxSnitches == 0:
+		fmt.Printf("Snitches: %d, no limit set\n", len(snitches))
+	case !planChosen():
+		fmt.Printf("Snitches: %d, the limit of %d is not enforced until plan is set\n", len(snitches), policy.MaxSnitches)
+	default:
+		headroom := policy.MaxSnitches - len(snitches)
+		if headroom < 0 {
+			headroom = 0
+		}
+		fmt.Printf("Snitches: %d of %d, room for %d more\n", len(snitches), policy.MaxSnitches, headroom)
+	}
+
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\n", "Interval", "Alert Types")
+	for _, interval := range intervals {
+		types := policy.Alerts[interval]
+		if len(types) == 0 {
+			types = []string{"none"}
+		}
+		fmt.Fprintf(w, "%s\t%s\n", interval, strings.Join(types, ", "))
+	}
+	w.Flush()
+
+	if err == nil {
+		for _, s := range snitches {
+			if !policy.allows(s.AlertType, s.Interval) {
+				fmt.Printf("WARNING: %s (%s) is a %s %s snitch, which the %s plan does not allow\n", s.Token, s.Name, s.Interval, s.AlertType, plan)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+// plan_test.go
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPlan(t *testing.T) {
+	defer viper.Set("plans", nil)
+	viper.Set("plans", map[string]interface{}{
+		"small": map[string]interface{}{"max-snitches": 3, "alerts": map[string]interface{}{"daily": []string{"basic", "smart"}}},
+		"team":  map[string]interface{}{"description": "Team"},
+	})
+
+	tests := []struct {
+		plan     string
+		alert    string
+		interval string
+		want     bool
+	}{
+		{"free", "basic", "daily", true},
+		{"free", "smart", "daily", false},
+		{"large", "smart", "hourly", true},
+		{"large", "smart", "15_minute", false},
+		{"small", "smart", "monthly", true},
+		// the config adds smart alerts to daily snitches and leaves the other intervals alone
+		{"small", "smart", "daily", true},
+		{"small", "smart", "weekly", false},
+		{"Small", "smart", "", true},
+		{"team", "basic", "hourly", true},
+		{"team", "smart", "monthly", false},
+		{"unknown", "basic", "daily", true},
+		{"unknown", "smart", "daily", false},
+	}
+	for _, test := range tests {
+		if got := checkPlan(test.plan, test.alert, test.interval); got != test.want {
+			t.Errorf("checkPlan(%q, %q, %q) = %v, want %v", test.plan, test.alert, test.interval, got, test.want)
+		}
+	}
+
+	quotas := []struct {
+		plan   string
+		chosen bool
+		count  int
+		ok     bool
+	}{
+		// plan defaults to free, which only limits the snitches once it is chosen
+		{"free", false, 100, true},
+		{"free", true, 0, true},
+		{"free", true, 1, false},
+		{"small", true, 2, true},
+		{"small", true, 3, false},
+		{"large", true, 1499, true},
+		{"large", true, 1500, false},
+		{"team", true, 100, true},
+	}
+	defer delete(configorigin, "plan")
+	for _, quota := range quotas {
+		delete(configorigin, "plan")
+		if quota.chosen {
+			configorigin["plan"] = "config.yaml"
+		}
+		if err := checkQuota(quota.plan, quota.count); (err == nil) != quota.ok {
+			t.Errorf("checkQuota(%q, %d) with the plan chosen %v = %v, want ok %v", quota.plan, quota.count, quota.chosen, err, quota.ok)
+		}
+	}
+}
+
+func TestImportCrontabQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-crontab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer viper.Set("plan", nil)
+	defer viper.Set("plans", nil)
+	defer delete(configorigin, "plan")
+	viper.Set("plan", "small")
+	configorigin["plan"] = "config.yaml"
+	viper.Set("plans", map[string]interface{}{"small": map[string]interface{}{"max-snitches": 2}})
+
+	created, cleanup := fakeAPI(t, []oneSnitch{{Token: "old1", Name: "elsewhere", Interval: "daily"}})
+	defer cleanup()
+
+	crontab := filepath.Join(dir, "crontab")
+	ioutil.WriteFile(crontab, []byte("0 1 * * * /bin/first\n0 2 * * * /bin/second\n0 3 * * * /bin/third\n"), 0600)
+
+	// the account has room for one more snitch, so a dry run and a real import both skip the last two
+	for _, output := range []string{"", filepath.Join(dir, "crontab.new")} {
+		viper.Set("output", output)
+		shown := captureStdout(t, func() { importCrontab(crontab) })
+		if skipped := strings.Count(shown, "the small plan allows 2 snitches and the account has 2"); skipped != 2 {
+			t.Errorf("import with output %q skipped %d over the quota, want 2:\n%s", output, skipped, shown)
+		}
+	}
+	viper.Set("output", nil)
+
+	if got := created(); len(got) != 1 || !strings.HasSuffix(got[0].Name, " first") {
+		t.Errorf("created %+v, want only the first", got)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+// probe.go
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func probe() {
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	var run func(timeout time.Duration) (string, error)
+
+	switch strings.ToLower(pflag.Arg(1)) {
+	case "http":
+		if viper.GetString("url") == "" {
+			fmt.Println("ERROR: --url cannot be blank")
+			os.Exit(1)
+		}
+		run = probeHTTP
+	case "tcp":
+		if viper.GetString("address") == "" {
+			fmt.Println("ERROR: --address cannot be blank")
+			os.Exit(1)
+		}
+		run = probeTCP
+	case "dns":
+		if viper.GetString("query") == "" {
+			fmt.Println("ERROR: --query cannot be blank")
+			os.Exit(1)
+		}
+		run = probeDNS
+	default:
+		fmt.Println("ERROR: Invalid probe", pflag.Arg(1), ". Please choose either \"http\", \"tcp\" or \"dns\"")
+		os.Exit(1)
+	}
+
+	for _, pattern := range []string{"expect-body", "expect-answer"} {
+		if _, err := regexp.Compile(viper.GetString(pattern)); err != nil {
+			fmt.Println("ERROR: Invalid --"+pattern, "regex:", err)
+			os.Exit(1)
+		}
+	}
+
+	timeout := viper.GetDuration("timeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	beat := func() (string, error) {
+		msg, err := run(timeout)
+		if err != nil {
+			return "", err
+		}
+		if custom := customMessage(messageFacts{Snitch: snitch, Message: msg}); custom != "" {
+			msg = custom
+		}
+		return msg, nil
+	}
+
+	// with --every the probe runs inside the heartbeat loop and a failed probe skips that check in
+	if viper.GetDuration("every") > 0 {
+		runHeartbeat(heartbeatConditions(), beat)
+		return
+	}
+
+	msg, err := beat()
+	if err != nil {
+		fmt.Println("ERROR: Probe failed,", err)
+		os.Exit(1)
+	}
+
+	if !silent {
+		fmt.Println("Probe:", msg)
+	}
+
+	if err := sendCheckIn(snitch, msg, ""); err != nil {
+		fmt.Println("ERROR: Check in failed:", err)
+		os.Exit(1)
+	}
+
+	if !silent {
+		fmt.Println("Success")
+	}
+}
+
+func probeHTTP(timeout time.Duration) (string, error) {
+	target := viper.GetString("url")
+
+	client := &http.Client{}
+	client.Timeout = timeout
+
+	start := time.Now()
+	resp, err := client.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	latency := time.Since(start)
+	if err != nil {
+		return "", err
+	}
+
+	expectstatus := viper.GetInt("expect-status")
+	if expectstatus != 0 {
+		if resp.StatusCode != expectstatus {
+			return "", fmt.Errorf("%s returned %d, expected %d in %s", target, resp.StatusCode, expectstatus, formatLatency(latency))
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%s returned %d in %s", target, resp.StatusCode, formatLatency(latency))
+	}
+
+	if viper.GetString("expect-body") != "" {
+		if !regexp.MustCompile(viper.GetString("expect-body")).Match(body) {
+			return "", fmt.Errorf("%s body does not match %q", target, viper.GetString("expect-body"))
+		}
+	}
+
+	result := fmt.Sprintf("http %s %d in %s", target, resp.StatusCode, formatLatency(latency))
+
+	if resp.TLS != nil {
+		expiry, err := checkCertExpiry(resp.TLS)
+		if err != nil {
+			return "", fmt.Errorf("%s %s", target, err)
+		}
+		result = result + ", " + expiry
+	}
+
+	return result, nil
+}
+
+func probeTCP(timeout time.Duration) (string, error) {
+	address := viper.GetString("address")
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	latency := time.Since(start)
+
+	result := fmt.Sprintf("tcp %s connected in %s", address, formatLatency(latency))
+
+	if viper.GetBool("tls") {
+		host, _, _ := net.SplitHostPort(address)
+		tlsconn := tls.Client(conn, &tls.Config{ServerName: host})
+		tlsconn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsconn.Handshake(); err != nil {
+			return "", fmt.Errorf("%s tls handshake failed: %s", address, err)
+		}
+		latency = time.Since(start)
+		state := tlsconn.ConnectionState()
+		expiry, err := checkCertExpiry(&state)
+		if err != nil {
+			return "", fmt.Errorf("%s %s", address, err)
+		}
+		result = fmt.Sprintf("tcp %s tls handshake in %s, %s", address, formatLatency(latency), expiry)
+	}
+
+	return result, nil
+}
+
+func probeDNS(timeout time.Duration) (string, error) {
+	query := viper.GetString("query")
+	recordtype := strings.ToUpper(viper.GetString("record-type"))
+	if recordtype == "" {
+		recordtype = "A"
+	}
+
+	resolver := &net.Resolver{}
+	if server := viper.GetString("resolver"); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver.PreferGo = true
+		resolver.Dial = func(ctx context.Context, network string, address string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, server)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var answers []string
+	var err error
+
+	start := time.Now()
+	switch recordtype {
+	case "A", "AAAA":
+		var addrs []net.IPAddr
+		addrs, err = resolver.LookupIPAddr(ctx, query)
+		for _, addr := range addrs {
+			if (addr.IP.To4() != nil) == (recordtype == "A") {
+				answers = append(answers, addr.IP.String())
+			}
+		}
+	case "CNAME":
+		var cname string
+		cname, err = resolver.LookupCNAME(ctx, query)
+		answers = append(answers, cname)
+	case "MX":
+		var mxs []*net.MX
+		mxs, err = resolver.LookupMX(ctx, query)
+		for _, mx := range mxs {
+			answers = append(answers, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+	case "NS":
+		var nss []*net.NS
+		nss, err = resolver.LookupNS(ctx, query)
+		for _, ns := range nss {
+			answers = append(answers, ns.Host)
+		}
+	case "TXT":
+		answers, err = resolver.LookupTXT(ctx, query)
+	default:
+		return "", fmt.Errorf("unsupported record type %s, please choose either A, AAAA, CNAME, MX, NS or TXT", recordtype)
+	}
+	latency := time.Since(start)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(answers) == 0 {
+		return "", fmt.Errorf("no %s records for %s", recordtype, query)
+	}
+
+	if viper.GetString("expect-answer") != "" {
+		expect := regexp.MustCompile(viper.GetString("expect-answer"))
+		found := false
+		for _, answer := range answers {
+			if expect.MatchString(answer) {
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("no %s record for %s matches %q, got %s", recordtype, query, viper.GetString("expect-answer"), strings.Join(answers, ","))
+		}
+	}
+
+	return fmt.Sprintf("dns %s %s %s in %s", query, recordtype, strings.Join(answers, ","), formatLatency(latency)), nil
+}
+
+// checkCertExpiry fails when the first certificate in the chain to expire does so within --cert-expiry
+func checkCertExpiry(state *tls.ConnectionState) (string, error) {
+	if len(state.PeerCertificates) == 0 {
+		return "", fmt.Errorf("no certificates presented")
+	}
+
+	expires := state.PeerCertificates[0].NotAfter
+	for _, cert := range state.PeerCertificates {
+		if cert.NotAfter.Before(expires) {
+			expires = cert.NotAfter
+		}
+	}
+
+	remaining := time.Until(expires)
+	days := int(remaining.Hours() / 24)
+
+	if threshold := viper.GetDuration("cert-expiry"); threshold > 0 && remaining < threshold {
+		return "", fmt.Errorf("certificate expires in %d days on %s", days, expires.Format("2006-01-02"))
+	}
+
+	return fmt.Sprintf("cert expires in %d days", days), nil
+}
+
+func formatLatency(latency time.Duration) string {
+	return fmt.Sprintf("%dms", latency.Nanoseconds()/int64(time.Millisecond))
+}
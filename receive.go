@@ -0,0 +1,279 @@
+package main
+
+// receive.go
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookEvent is the payload deadmanssnitch.com posts to a webhook integration
+type webhookEvent struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      struct {
+		Snitch webhookSnitch `json:"snitch"`
+	} `json:"data"`
+}
+
+type webhookSnitch struct {
+	Token          string   `json:"token"`
+	Name           string   `json:"name"`
+	Tags           []string `json:"tags"`
+	Notes          string   `json:"notes"`
+	Status         string   `json:"status"`
+	PreviousStatus string   `json:"previous_status"`
+}
+
+// receiveRule maps matching webhook events to a remediation command
+type receiveRule struct {
+	Name    string        `mapstructure:"name"`
+	Token   string        `mapstructure:"token"`
+	Tags    []string      `mapstructure:"tags"`
+	Events  []string      `mapstructure:"events"`
+	Command string        `mapstructure:"command"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type receiveAudit struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Token    string    `json:"token"`
+	Name     string    `json:"name"`
+	Rule     string    `json:"rule"`
+	Command  string    `json:"command"`
+	ExitCode int       `json:"exit_code"`
+	Duration string    `json:"duration"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type receiver struct {
+	rules    []receiveRule
+	secret   string
+	slots    chan struct{}
+	auditlog string
+	auditmu  sync.Mutex
+}
+
+func receiveWebhooks() {
+	var rules []receiveRule
+	if err := viper.UnmarshalKey("receive.rules", &rules); err != nil {
+		fmt.Println("ERROR: Cannot read receive.rules from config:", err)
+		os.Exit(1)
+	}
+
+	for i := range rules {
+		if rules[i].Name == "" {
+			rules[i].Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if rules[i].Command == "" {
+			fmt.Println("ERROR: receive rule", rules[i].Name, "has no command")
+			os.Exit(1)
+		}
+		if rules[i].Token == "" && len(rules[i].Tags) == 0 {
+			fmt.Println("ERROR: receive rule", rules[i].Name, "needs a token or tags to match on")
+			os.Exit(1)
+		}
+		if len(rules[i].Events) == 0 {
+			// by default only remediate when a snitch goes bad
+			rules[i].Events = []string{"missing", "errored"}
+		}
+		if rules[i].Timeout == 0 {
+			rules[i].Timeout = viper.GetDuration("receive.timeout")
+		}
+		if rules[i].Timeout == 0 {
+			rules[i].Timeout = time.Minute
+		}
+	}
+
+	if len(rules) == 0 {
+		fmt.Println("ERROR: No receive.rules defined in config")
+		os.Exit(1)
+	}
+
+	concurrency := viper.GetInt("receive.concurrency")
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	r := &receiver{
+		rules:    rules,
+		secret:   secretSetting("receive.secret"),
+		slots:    make(chan struct{}, concurrency),
+		auditlog: viper.GetString("receive.auditlog"),
+	}
+
+	listen := viper.GetString("listen")
+	if listen == "" {
+		listen = viper.GetString("receive.listen")
+	}
+	if listen == "" {
+		listen = ":8080"
+	}
+
+	if !silent {
+		fmt.Printf("Listening for webhooks on %s with %d rules\n", listen, len(rules))
+	}
+
+	http.HandleFunc("/", r.handleWebhook)
+	log.Fatal(http.ListenAndServe(listen, nil))
+}
+
+func (r *receiver) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.secret != "" {
+		if subtle.ConstantTimeCompare([]byte(req.URL.Query().Get("secret")), []byte(r.secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if !strings.HasPrefix(event.Type, "snitch.") || event.Data.Snitch.Token == "" {
+		http.Error(w, "not a snitch webhook", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if verbose {
+		fmt.Printf("Webhook: %s %s (%s) %s -> %s\n", event.Type, event.Data.Snitch.Token, event.Data.Snitch.Name, event.Data.Snitch.PreviousStatus, event.Data.Snitch.Status)
+	}
+
+	matched := 0
+	for _, rule := range r.rules {
+		if rule.matches(event) {
+			matched++
+			go r.remediate(rule, event)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "matched %d rules\n", matched)
+}
+
+func (rule receiveRule) matches(event webhookEvent) bool {
+	kind := strings.TrimPrefix(event.Type, "snitch.")
+
+	eventmatch := false
+	for _, e := range rule.Events {
+		if strings.ToLower(e) == kind || e == "*" {
+			eventmatch = true
+		}
+	}
+	if !eventmatch {
+		return false
+	}
+
+	if rule.Token != "" && rule.Token != event.Data.Snitch.Token {
+		return false
+	}
+
+	if len(rule.Tags) != 0 {
+		for _, want := range rule.Tags {
+			for _, have := range event.Data.Snitch.Tags {
+				if strings.ToLower(want) == strings.ToLower(have) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func (r *receiver) remediate(rule receiveRule, event webhookEvent) {
+	// wait for a free slot so a storm of webhooks cannot fork bomb the host
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rule.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", rule.Command)
+	cmd.Env = append(os.Environ(),
+		"SNITCHIT_EVENT="+event.Type,
+		"SNITCHIT_TOKEN="+event.Data.Snitch.Token,
+		"SNITCHIT_NAME="+event.Data.Snitch.Name,
+		"SNITCHIT_STATUS="+event.Data.Snitch.Status,
+		"SNITCHIT_TAGS="+strings.Join(event.Data.Snitch.Tags, ","),
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err := cmd.Run()
+
+	entry := receiveAudit{
+		Time:     start,
+		Event:    event.Type,
+		Token:    event.Data.Snitch.Token,
+		Name:     event.Data.Snitch.Name,
+		Rule:     rule.Name,
+		Command:  rule.Command,
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+		Output:   strings.TrimSpace(output.String()),
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		entry.Error = "timed out after " + rule.Timeout.String()
+	} else if err != nil {
+		entry.Error = err.Error()
+	}
+	if len(entry.Output) > 4096 {
+		entry.Output = entry.Output[len(entry.Output)-4096:]
+	}
+
+	r.audit(entry)
+}
+
+func (r *receiver) audit(entry receiveAudit) {
+	r.auditmu.Lock()
+	defer r.auditmu.Unlock()
+
+	if !silent {
+		fmt.Printf("Remediation: rule=%s snitch=%s event=%s exit=%d duration=%s %s\n", entry.Rule, entry.Token, entry.Event, entry.ExitCode, entry.Duration, entry.Error)
+	}
+
+	if r.auditlog == "" {
+		return
+	}
+
+	line, _ := json.Marshal(entry)
+	f, err := os.OpenFile(r.auditlog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("ERROR: Cannot open audit log:", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
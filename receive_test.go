@@ -0,0 +1,129 @@
+package main
+
+// receive_test.go
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func webhook(kind string, token string, tags ...string) webhookEvent {
+	var event webhookEvent
+	event.Type = "snitch." + kind
+	event.Data.Snitch.Token = token
+	event.Data.Snitch.Name = "backups"
+	event.Data.Snitch.Tags = tags
+	return event
+}
+
+func TestReceiveRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule  receiveRule
+		event webhookEvent
+		want  bool
+	}{
+		{receiveRule{Token: "abc", Events: []string{"missing", "errored"}}, webhook("missing", "abc"), true},
+		{receiveRule{Token: "abc", Events: []string{"missing", "errored"}}, webhook("errored", "abc"), true},
+		{receiveRule{Token: "abc", Events: []string{"missing", "errored"}}, webhook("reporting", "abc"), false},
+		{receiveRule{Token: "abc", Events: []string{"missing"}}, webhook("missing", "def"), false},
+		{receiveRule{Token: "abc", Events: []string{"*"}}, webhook("paused", "abc"), true},
+		{receiveRule{Token: "abc", Events: []string{"MISSING"}}, webhook("missing", "abc"), true},
+		{receiveRule{Tags: []string{"db"}, Events: []string{"missing"}}, webhook("missing", "abc", "web", "DB"), true},
+		{receiveRule{Tags: []string{"db"}, Events: []string{"missing"}}, webhook("missing", "abc", "web"), false},
+		{receiveRule{Tags: []string{"db"}, Events: []string{"missing"}}, webhook("missing", "abc"), false},
+		{receiveRule{Token: "abc", Tags: []string{"db"}, Events: []string{"missing"}}, webhook("missing", "def", "db"), false},
+	}
+	for _, test := range tests {
+		if got := test.rule.matches(test.event); got != test.want {
+			t.Errorf("%+v matches %s %s %v = %v, want %v", test.rule, test.event.Type, test.event.Data.Snitch.Token, test.event.Data.Snitch.Tags, got, test.want)
+		}
+	}
+}
+
+func TestReceiveWebhook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-receive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s bool) { silent = s }(silent)
+	silent = true
+
+	r := &receiver{
+		rules: []receiveRule{
+			{Name: "restart", Token: "abc", Events: []string{"missing"}, Command: `echo "$SNITCHIT_EVENT $SNITCHIT_TOKEN $SNITCHIT_TAGS"`, Timeout: time.Minute},
+			{Name: "slow", Tags: []string{"slow"}, Events: []string{"missing"}, Command: "exec sleep 5", Timeout: 100 * time.Millisecond},
+		},
+		secret:   "s3cret",
+		slots:    make(chan struct{}, 2),
+		auditlog: filepath.Join(dir, "audit.jsonl"),
+	}
+
+	event := func(e webhookEvent) string {
+		data, _ := json.Marshal(e)
+		return string(data)
+	}
+
+	tests := []struct {
+		method string
+		query  string
+		body   string
+		status int
+		reply  string
+	}{
+		{"GET", "?secret=s3cret", "", http.StatusMethodNotAllowed, ""},
+		{"POST", "", event(webhook("missing", "abc")), http.StatusForbidden, ""},
+		{"POST", "?secret=wrong", event(webhook("missing", "abc")), http.StatusForbidden, ""},
+		{"POST", "?secret=s3cret", "{not json", http.StatusBadRequest, ""},
+		{"POST", "?secret=s3cret", `{"type": "account.updated"}`, http.StatusUnprocessableEntity, ""},
+		{"POST", "?secret=s3cret", event(webhook("reporting", "abc")), http.StatusAccepted, "matched 0 rules\n"},
+		{"POST", "?secret=s3cret", event(webhook("missing", "abc", "db", "nightly")), http.StatusAccepted, "matched 1 rules\n"},
+		{"POST", "?secret=s3cret", event(webhook("missing", "def", "slow")), http.StatusAccepted, "matched 1 rules\n"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/"+test.query, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		r.handleWebhook(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s %q = %d, want %d", test.method, test.query, test.body, w.Code, test.status)
+		}
+		if test.reply != "" && w.Body.String() != test.reply {
+			t.Errorf("%s %s %q replied %q, want %q", test.method, test.query, test.body, w.Body.String(), test.reply)
+		}
+	}
+
+	// remediation runs in the background, wait for both commands to be audited
+	var entries []receiveAudit
+	for wait := 0; wait < 50 && len(entries) < 2; wait++ {
+		time.Sleep(100 * time.Millisecond)
+		data, _ := ioutil.ReadFile(r.auditlog)
+		entries = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var entry receiveAudit
+			if json.Unmarshal([]byte(line), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want 2", len(entries))
+	}
+
+	byrule := make(map[string]receiveAudit)
+	for _, entry := range entries {
+		byrule[entry.Rule] = entry
+	}
+	if entry := byrule["restart"]; entry.ExitCode != 0 || entry.Output != "snitch.missing abc db,nightly" || entry.Error != "" {
+		t.Errorf("restart remediation = %+v", entry)
+	}
+	if entry := byrule["slow"]; !strings.HasPrefix(entry.Error, "timed out") {
+		t.Errorf("slow remediation = %+v, want a timeout", entry)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+// relay.go
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relayCheckIn is a check in waiting in the spool to be forwarded upstream
+type relayCheckIn struct {
+	Token    string    `json:"token"`
+	Message  string    `json:"m"`
+	Status   string    `json:"s,omitempty"`
+	Received time.Time `json:"received"`
+	From     string    `json:"from"`
+}
+
+type relay struct {
+	upstream string
+	spool    string
+	secret   string
+	tokens   map[string]bool
+	retry    time.Duration
+	wake     chan struct{}
+	seqmu    sync.Mutex
+	seq      int64
+}
+
+func relayCheckIns() {
+	r := &relay{
+		upstream: viper.GetString("relay.upstream"),
+		spool:    viper.GetString("relay.spool"),
+		secret:   secretSetting("relay.secret"),
+		tokens:   make(map[string]bool),
+		retry:    viper.GetDuration("relay.retry"),
+		wake:     make(chan struct{}, 1),
+	}
+
+	if r.upstream == "" {
+		r.upstream = "https://nosnch.in"
+	}
+	if r.spool == "" {
+		r.spool = "/var/spool/snitchit"
+	}
+	if r.retry <= 0 {
+		r.retry = 30 * time.Second
+	}
+	for _, token := range viper.GetStringSlice("relay.tokens") {
+		r.tokens[token] = true
+	}
+
+	// never run as an open relay
+	if len(r.tokens) == 0 && r.secret == "" {
+		fmt.Println("ERROR: relay needs relay.tokens and/or relay.secret defined in config")
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(r.spool, 0700); err != nil {
+		fmt.Println("ERROR: Cannot create spool directory", r.spool, ":", err)
+		os.Exit(1)
+	}
+
+	listen := viper.GetString("listen")
+	if listen == "" {
+		listen = viper.GetString("relay.listen")
+	}
+	if listen == "" {
+		listen = ":8443"
+	}
+
+	go r.forward()
+	r.kick()
+
+	if !silent {
+		fmt.Printf("Relaying check ins on %s to %s, spooling to %s\n", listen, r.upstream, r.spool)
+	}
+
+	http.HandleFunc("/", r.handleCheckIn)
+
+	tlscert := viper.GetString("relay.tlscert")
+	tlskey := viper.GetString("relay.tlskey")
+	if tlscert != "" && tlskey != "" {
+		log.Fatal(http.ListenAndServeTLS(listen, tlscert, tlskey, nil))
+	}
+	log.Fatal(http.ListenAndServe(listen, nil))
+}
+
+func (r *relay) handleCheckIn(w http.ResponseWriter, req *http.Request) {
+	// HEAD is left out, as link checkers and proxies send it without meaning to check in
+	if req.Method != "GET" && req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.Trim(req.URL.Path, "/")
+	if token == "" || strings.Contains(token, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if r.secret != "" {
+		_, password, _ := req.BasicAuth()
+		if password == "" {
+			password = req.URL.Query().Get("secret")
+		}
+		if subtle.ConstantTimeCompare([]byte(password), []byte(r.secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	if len(r.tokens) != 0 && !r.tokens[token] {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	checkin := relayCheckIn{
+		Token:    token,
+		Message:  req.Form.Get("m"),
+		Status:   req.Form.Get("s"),
+		Received: time.Now(),
+		From:     req.RemoteAddr,
+	}
+
+	if err := r.enqueue(checkin); err != nil {
+		log.Println("ERROR: Cannot spool check in:", err)
+		http.Error(w, "cannot spool check in", http.StatusInternalServerError)
+		return
+	}
+
+	if verbose {
+		fmt.Println("Relay: spooled check in for", token, "from", req.RemoteAddr)
+	}
+
+	r.kick()
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "Got it, thanks!")
+}
+
+// enqueue writes the check in to the spool, file names sort in arrival order
+func (r *relay) enqueue(checkin relayCheckIn) error {
+	r.seqmu.Lock()
+	r.seq++
+	name := fmt.Sprintf("%019d-%06d.json", checkin.Received.UnixNano(), r.seq%1000000)
+	r.seqmu.Unlock()
+
+	data, err := json.Marshal(checkin)
+	if err != nil {
+		return err
+	}
+
+	// write then rename so the forwarder never sees a half written file
+	tmp := filepath.Join(r.spool, "."+name)
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.spool, name))
+}
+
+func (r *relay) kick() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward replays the spool upstream in order, stopping at the first failure
+// so that check ins are never delivered out of order
+func (r *relay) forward() {
+	for {
+		if !r.replay() {
+			select {
+			case <-r.wake:
+			case <-time.After(r.retry):
+			}
+			continue
+		}
+		<-r.wake
+	}
+}
+
+func (r *relay) replay() bool {
+	files, err := ioutil.ReadDir(r.spool)
+	if err != nil {
+		log.Println("ERROR: Cannot read spool:", err)
+		return false
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		spoolfile := filepath.Join(r.spool, name)
+		data, err := ioutil.ReadFile(spoolfile)
+		if err != nil {
+			log.Println("ERROR: Cannot read spooled check in:", err)
+			return false
+		}
+
+		var checkin relayCheckIn
+		if err := json.Unmarshal(data, &checkin); err != nil {
+			log.Println("ERROR: Dropping corrupt spooled check in", name, ":", err)
+			os.Remove(spoolfile)
+			continue
+		}
+
+		statuscode, _, err := postCheckIn(r.upstream, checkin.Token, checkin.Message, checkin.Status)
+		// rate limits and request timeouts pass, so keep the check in for the next retry
+		if err != nil || statuscode >= 500 || statuscode == http.StatusTooManyRequests || statuscode == http.StatusRequestTimeout {
+			if verbose {
+				fmt.Println("Relay: upstream unavailable, will retry in", r.retry, ":", err, statuscode)
+			}
+			return false
+		}
+
+		if statuscode >= 400 {
+			// upstream will never accept this one, do not let it block the queue
+			log.Println("ERROR: Upstream rejected check in for", checkin.Token, ":", statuscode, http.StatusText(statuscode))
+		} else if verbose {
+			fmt.Println("Relay: forwarded check in for", checkin.Token, "received", checkin.Received.Format(time.RFC3339))
+		}
+
+		os.Remove(spoolfile)
+	}
+
+	return true
+}
@@ -0,0 +1,168 @@
+package main
+
+// relay_test.go
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testRelay(t *testing.T, upstream string) (*relay, func()) {
+	spool, err := ioutil.TempDir("", "snitchit-relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &relay{
+		upstream: upstream,
+		spool:    spool,
+		secret:   "s3cret",
+		tokens:   map[string]bool{"abc": true, "def": true},
+		retry:    time.Minute,
+		wake:     make(chan struct{}, 1),
+	}, func() { os.RemoveAll(spool) }
+}
+
+// spooled returns the tokens of the check ins waiting in the spool, oldest first
+func spooled(t *testing.T, r *relay) []string {
+	files, err := ioutil.ReadDir(r.spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names, tokens []string
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var checkin relayCheckIn
+		data, _ := ioutil.ReadFile(filepath.Join(r.spool, name))
+		if err := json.Unmarshal(data, &checkin); err != nil {
+			tokens = append(tokens, "corrupt")
+			continue
+		}
+		tokens = append(tokens, checkin.Token+"="+checkin.Message)
+	}
+	return tokens
+}
+
+func TestRelayHandleCheckIn(t *testing.T) {
+	r, cleanup := testRelay(t, "")
+	defer cleanup()
+
+	tests := []struct {
+		method string
+		target string
+		user   string
+		status int
+	}{
+		{"HEAD", "/abc?secret=s3cret", "", http.StatusMethodNotAllowed},
+		{"PUT", "/abc?secret=s3cret", "", http.StatusMethodNotAllowed},
+		{"GET", "/?secret=s3cret", "", http.StatusNotFound},
+		{"GET", "/abc/extra?secret=s3cret", "", http.StatusNotFound},
+		{"GET", "/abc", "", http.StatusForbidden},
+		{"GET", "/abc?secret=wrong", "", http.StatusForbidden},
+		{"GET", "/xyz?secret=s3cret", "", http.StatusForbidden},
+		{"GET", "/abc?secret=s3cret&m=first", "", http.StatusAccepted},
+		{"POST", "/def?m=second", "s3cret", http.StatusAccepted},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, nil)
+		if test.user != "" {
+			req.SetBasicAuth("snitchit", test.user)
+		}
+		w := httptest.NewRecorder()
+		r.handleCheckIn(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s = %d, want %d", test.method, test.target, w.Code, test.status)
+		}
+	}
+
+	if got, want := spooled(t, r), []string{"abc=first", "def=second"}; !equalStrings(got, want) {
+		t.Errorf("spool = %v, want %v", got, want)
+	}
+}
+
+func TestRelayReplay(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	status := http.StatusOK
+	reject := ""
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		req.ParseForm()
+		token := strings.Trim(req.URL.Path, "/")
+		if token == reject {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if status == http.StatusOK {
+			delivered = append(delivered, token+"="+req.Form.Get("m"))
+		}
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+
+	r, cleanup := testRelay(t, upstream.URL)
+	defer cleanup()
+
+	received := time.Now()
+	for i, checkin := range []string{"abc=1", "gone=2", "def=3"} {
+		parts := strings.SplitN(checkin, "=", 2)
+		if err := r.enqueue(relayCheckIn{Token: parts[0], Message: parts[1], Received: received.Add(time.Duration(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(r.spool, "0000000000000000000-000000.json"), []byte("{corrupt"), 0600)
+
+	// while upstream is unavailable, rate limiting or timing out every check in stays spooled
+	for _, unavailable := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusRequestTimeout} {
+		mu.Lock()
+		status = unavailable
+		mu.Unlock()
+		if r.replay() {
+			t.Errorf("replay with upstream answering %d succeeded", unavailable)
+		}
+		if got, want := spooled(t, r), []string{"abc=1", "gone=2", "def=3"}; !equalStrings(got, want) {
+			t.Errorf("spool after upstream answered %d = %v, want %v", unavailable, got, want)
+		}
+	}
+
+	// once upstream is back the spool is delivered in order, dropping what upstream will never accept
+	mu.Lock()
+	status = http.StatusOK
+	reject = "gone"
+	mu.Unlock()
+	if !r.replay() {
+		t.Errorf("replay with upstream available failed")
+	}
+	if got := spooled(t, r); len(got) != 0 {
+		t.Errorf("spool after replay = %v, want it empty", got)
+	}
+	if want := []string{"abc=1", "def=3"}; !equalStrings(delivered, want) {
+		t.Errorf("delivered %v, want %v", delivered, want)
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
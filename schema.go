@@ -0,0 +1,464 @@
+package main
+
+// schema.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configSchema describes a config setting, both as the JSON Schema published for editors and as the rules config lint enforces
+type configSchema struct {
+	Schema               string                   `json:"$schema,omitempty"`
+	Title                string                   `json:"title,omitempty"`
+	Type                 string                   `json:"type,omitempty"`
+	Description          string                   `json:"description,omitempty"`
+	Enum                 []string                 `json:"enum,omitempty"`
+	Pattern              string                   `json:"pattern,omitempty"`
+	UniqueItems          bool                     `json:"uniqueItems,omitempty"`
+	Items                *configSchema            `json:"items,omitempty"`
+	Properties           map[string]*configSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}              `json:"additionalProperties,omitempty"` // false, or the schema of each value of a map such as jobs
+
+	check func(string) error // further checks of a text value
+}
+
+var intervals = []string{"15_minute", "30_minute", "hourly", "daily", "weekly", "monthly"}
+
+const durationPattern = `^(0|-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+
+func schemaString(description string) *configSchema {
+	return &configSchema{Type: "string", Description: description}
+}
+
+func schemaDuration(description string) *configSchema {
+	return &configSchema{Type: "string", Description: description, Pattern: durationPattern, check: func(value string) error {
+		_, err := time.ParseDuration(value)
+		return err
+	}}
+}
+
+func schemaSnitch(description string) *configSchema {
+	return &configSchema{Type: "string", Description: description, Pattern: `^\S+$`, check: func(value string) error {
+		if value == "" || strings.ContainsAny(value, " \t\r\n") {
+			return fmt.Errorf("%q is not a snitch token or alias", value)
+		}
+		return nil
+	}}
+}
+
+func schemaList(items *configSchema, description string) *configSchema {
+	return &configSchema{Type: "array", Description: description, Items: items}
+}
+
+func schemaObject(description string, properties map[string]*configSchema) *configSchema {
+	return &configSchema{Type: "object", Description: description, Properties: properties, AdditionalProperties: false}
+}
+
+func schemaMap(description string, values *configSchema) *configSchema {
+	return &configSchema{Type: "object", Description: description, AdditionalProperties: values}
+}
+
+// settingsSchema is the schema of the settings which can be set at the top level of the config or in a profile.
+// Every command line option is a setting, plus the sections only the config file has.
+func settingsSchema() *configSchema {
+	settings := make(map[string]*configSchema)
+
+	pflag.CommandLine.VisitAll(func(f *pflag.Flag) {
+		switch f.Value.Type() {
+		case "bool":
+			settings[f.Name] = &configSchema{Type: "boolean", Description: f.Usage}
+		case "int":
+			settings[f.Name] = &configSchema{Type: "integer", Description: f.Usage}
+		case "duration":
+			settings[f.Name] = schemaDuration(f.Usage)
+		default:
+			settings[f.Name] = schemaString(f.Usage)
+		}
+	})
+
+	settings["alert"].Enum = []string{"basic", "smart"}
+	settings["alert"].check = func(value string) error {
+		if !checkAlertType(value) {
+			return fmt.Errorf("%q should be \"basic\" or \"smart\"", value)
+		}
+		return nil
+	}
+	settings["interval"].Enum = intervals
+	settings["interval"].check = func(value string) error {
+		if !checkInterval(value) {
+			return fmt.Errorf("%q should be one of %s", value, strings.Join(intervals, ", "))
+		}
+		return nil
+	}
+	settings["plan"].Enum = planNames()
+	settings["plan"].check = checkPlanName
+	settings["transport"].check = func(value string) error {
+		for _, transport := range strings.Split(value, ",") {
+			if !checkTransport(strings.TrimSpace(transport)) {
+				return fmt.Errorf("%q should be \"https\" or \"smtp\"", transport)
+			}
+		}
+		return nil
+	}
+	settings["snitch"] = schemaSnitch(settings["snitch"].Description)
+
+	settings["defaultsnitch"] = schemaSnitch("Snitch to use when --snitch is not given")
+	settings["snitches"] = schemaList(schemaSnitch(""), "Snitches to check on")
+	settings["snitches"].UniqueItems = true
+	settings["aliases"] = schemaMap("Names for snitch tokens, usable anywhere a token is", schemaSnitch(""))
+
+	transport := &configSchema{Type: "string", Enum: []string{"https", "http", "smtp"}, check: settings["transport"].check}
+	settings["transports"] = schemaList(transport, "Check in transports to try in order")
+	settings["transports"].UniqueItems = true
+
+	pattern := &configSchema{Type: "string", check: func(value string) error {
+		_, err := regexp.Compile(value)
+		return err
+	}}
+	settings["redact"] = schemaList(pattern, "Regexes of secrets to hide in job output, only the first group is kept when there is one")
+
+	settings["jobs"] = schemaMap("Commands to run and check in for", schemaObject("", map[string]*configSchema{
+		"command":      schemaString("Command to run, through /bin/sh"),
+		"snitch":       schemaSnitch("Snitch to check in to"),
+		"timeout":      schemaDuration("Kill the job after this long"),
+		"env":          schemaList(schemaString(""), "Environment variables, \"NAME=value\""),
+		"dir":          schemaString("Directory to run the job in"),
+		"schedule":     {Type: "string", Description: "Cron schedule for the scheduler, \"0 2 * * *\" or \"@daily\"", check: checkSchedule},
+		"max-duration": schemaDuration("Send an errored check in when the job runs for longer than this"),
+		"min-duration": schemaDuration("Send an errored check in when the job runs for less than this"),
+		"lock":         schemaString("Lock file so only one copy of the job runs at once"),
+		"lock-wait":    schemaDuration("How long to wait for the lock before skipping the run"),
+		"lease-dir":    schemaString("Shared directory for leases so only one host in a fleet runs the job"),
+		"lease-ttl":    schemaDuration("How long a lease lasts without renewal"),
+	}))
+
+	alerts := schemaList(&configSchema{Type: "string", Enum: []string{"basic", "smart"}, check: settings["alert"].check}, "")
+	plan := make(map[string]*configSchema)
+	for _, interval := range intervals {
+		plan[interval] = alerts
+	}
+	settings["plans"] = schemaMap("Plans, changing the built in free, small, medium and large plans or adding others", schemaObject("", map[string]*configSchema{
+		"description":  schemaString("Name of the plan"),
+		"max-snitches": {Type: "integer", Description: "Most snitches the account can have, 0 is unlimited"},
+		"alerts":       schemaObject("Alert types allowed for each interval", plan),
+	}))
+
+	settings["audit"] = schemaObject("Log of changes made to snitches", map[string]*configSchema{
+		"log":    schemaString("JSON lines file changes are appended to, default = [state-dir]/audit.jsonl, \"none\" to turn it off"),
+		"syslog": {Type: "boolean", Description: "Send changes to syslog too"},
+	})
+
+	settings["smtp"] = schemaObject("Mail server for the smtp transport", map[string]*configSchema{
+		"host":     schemaString("Mail server"),
+		"port":     {Type: "integer", Description: "Mail server port, default = 25"},
+		"domain":   schemaString("Domain of the check in address, default = nosnch.in"),
+		"from":     schemaString("Sender address"),
+		"timeout":  schemaDuration("Timeout talking to the mail server"),
+		"starttls": {Type: "boolean", Description: "Use STARTTLS"},
+		"insecure": {Type: "boolean", Description: "Do not verify the mail server certificate"},
+		"username": schemaString("Username for the mail server"),
+		"password": schemaString("Password for the mail server, or a secret reference"),
+	})
+
+	settings["relay"] = schemaObject("Settings for snitchit relay", map[string]*configSchema{
+		"listen":   schemaString("Address to listen on, \"host:port\""),
+		"upstream": schemaString("Where check ins are forwarded to"),
+		"spool":    schemaString("Directory check ins are queued in until they are delivered"),
+		"secret":   schemaString("Shared secret clients must send, or a secret reference"),
+		"retry":    schemaDuration("How often to retry queued check ins"),
+		"tokens":   schemaList(schemaSnitch(""), "Snitches the relay accepts check ins for"),
+		"tlscert":  schemaString("TLS certificate file"),
+		"tlskey":   schemaString("TLS key file"),
+	})
+
+	settings["receive"] = schemaObject("Settings for snitchit receive", map[string]*configSchema{
+		"listen":      schemaString("Address to listen on, \"host:port\""),
+		"secret":      schemaString("Shared secret the webhook must send, or a secret reference"),
+		"auditlog":    schemaString("File remediation commands are logged to"),
+		"concurrency": {Type: "integer", Description: "Number of remediation commands to run at once"},
+		"timeout":     schemaDuration("Default timeout for remediation commands"),
+		"rules": schemaList(schemaObject("", map[string]*configSchema{
+			"name":    schemaString("Name of the rule"),
+			"token":   schemaSnitch("Snitch the rule matches"),
+			"tags":    schemaList(schemaString(""), "Tags the rule matches"),
+			"events":  schemaList(schemaString(""), "Events the rule matches, default = missing and errored"),
+			"command": schemaString("Command to run"),
+			"timeout": schemaDuration("Kill the command after this long"),
+		}), "Remediation rules"),
+	})
+
+	return schemaObject("", settings)
+}
+
+// rootSchema is the schema of a whole config file, the settings plus any number of profiles of settings
+func rootSchema() *configSchema {
+	root := settingsSchema()
+	root.Schema = "http://json-schema.org/draft-07/schema#"
+	root.Title = "snitchit config"
+
+	profile := settingsSchema()
+	profile.Description = "Settings which override the top level when the profile is selected"
+	delete(profile.Properties, "profile")
+	root.Properties["profiles"] = schemaMap("Profiles selected with --profile", profile)
+	root.Properties["profile"] = schemaString("Profile to use")
+
+	return root
+}
+
+func checkPlanName(value string) error {
+	for _, plan := range planNames() {
+		if strings.EqualFold(value, plan) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q should be one of %s", value, strings.Join(planNames(), ", "))
+}
+
+func checkSchedule(value string) error {
+	_, err := parseCron(value)
+	return err
+}
+
+// lintValue checks value against schema, returning a problem for every unknown key, wrong type and invalid value
+func lintValue(path string, value interface{}, schema *configSchema) []string {
+	var problems []string
+
+	if value == nil {
+		// an empty setting is the same as one not set
+		return nil
+	}
+
+	switch schema.Type {
+	case "object":
+		settings, ok := value.(yaml.MapSlice)
+		if !ok {
+			return []string{fmt.Sprintf("%s should be a map", path)}
+		}
+		seen := make(map[string]bool)
+		for _, item := range settings {
+			// viper ignores the case of keys, so keys differing only in case are duplicates too
+			key := strings.ToLower(fmt.Sprint(item.Key))
+			keypath := key
+			if path != "" {
+				keypath = path + "." + key
+			}
+			if seen[key] {
+				problems = append(problems, fmt.Sprintf("%s is set more than once", keypath))
+				continue
+			}
+			seen[key] = true
+
+			child, ok := schema.Properties[key]
+			if !ok {
+				child, ok = schema.AdditionalProperties.(*configSchema)
+			}
+			if !ok {
+				problem := fmt.Sprintf("%s is not a known setting", keypath)
+				if suggestion := closestKey(key, schema.Properties); suggestion != "" {
+					problem += fmt.Sprintf(", did you mean %s?", suggestion)
+				}
+				problems = append(problems, problem)
+				continue
+			}
+			problems = append(problems, lintValue(keypath, item.Value, child)...)
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s should be a list", path)}
+		}
+		seen := make(map[string]bool)
+		for i, item := range list {
+			itempath := fmt.Sprintf("%s[%d]", path, i)
+			if schema.UniqueItems {
+				if seen[fmt.Sprint(item)] {
+					problems = append(problems, fmt.Sprintf("%s %v is listed more than once", itempath, item))
+				}
+				seen[fmt.Sprint(item)] = true
+			}
+			problems = append(problems, lintValue(itempath, item, schema.Items)...)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s should be true or false, not %v", path, value))
+		}
+	case "integer":
+		if _, ok := value.(int); !ok {
+			problems = append(problems, fmt.Sprintf("%s should be a whole number, not %v", path, value))
+		}
+	case "string":
+		switch value.(type) {
+		case string, int, float64:
+		default:
+			return []string{fmt.Sprintf("%s should be a single value", path)}
+		}
+		if schema.check != nil {
+			if err := schema.check(fmt.Sprint(value)); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", path, err))
+			}
+		}
+	}
+
+	return problems
+}
+
+// closestKey suggests the known key a misspelt key was probably meant to be
+func closestKey(key string, known map[string]*configSchema) string {
+	best, bestdistance := "", 3
+	for candidate := range known {
+		if distance := editDistance(key, candidate); distance < bestdistance || (distance == bestdistance && candidate < best) {
+			best, bestdistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// lintConfig checks every config file found against the schema, and that the plan of the config and each profile
+// allows its alert type and interval
+func lintConfig() []string {
+	var problems []string
+	root := rootSchema()
+
+	// the plan, alert and interval of the top level and of each profile, with later files overriding earlier ones
+	layers := map[string]map[string]string{"": {}}
+
+	for _, file := range configFiles() {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", file, err))
+			continue
+		}
+		var config yaml.MapSlice
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", file, err))
+			continue
+		}
+		for _, problem := range lintValue("", config, root) {
+			problems = append(problems, fmt.Sprintf("%s: %s", file, problem))
+		}
+
+		for _, key := range []string{"plan", "alert", "interval"} {
+			if value, ok := getConfigValue(config, []string{key}); ok && value != nil {
+				layers[""][key] = fmt.Sprint(value)
+			}
+		}
+		if value, ok := getConfigValue(config, []string{"profiles"}); ok {
+			profiles, _ := value.(yaml.MapSlice)
+			for _, profile := range profiles {
+				name := strings.ToLower(fmt.Sprint(profile.Key))
+				if layers[name] == nil {
+					layers[name] = make(map[string]string)
+				}
+				settings, _ := profile.Value.(yaml.MapSlice)
+				for _, key := range []string{"plan", "alert", "interval"} {
+					if value, ok := getConfigValue(settings, []string{key}); ok && value != nil {
+						layers[name][key] = fmt.Sprint(value)
+					}
+				}
+			}
+		}
+	}
+
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		setting := func(key string, fallback string) string {
+			if value, ok := layers[name][key]; ok {
+				return value
+			}
+			if value, ok := layers[""][key]; ok {
+				return value
+			}
+			return fallback
+		}
+		plan, alert, interval := setting("plan", "free"), setting("alert", "basic"), setting("interval", "")
+		if checkPlanName(plan) == nil && !checkPlan(plan, alert, interval) {
+			problem := planProblem(plan, alert, interval)
+			if name != "" {
+				problem = "profile " + name + ": " + problem
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems
+}
+
+// lintCommand is snitchit config lint
+func lintCommand() {
+	problems := lintConfig()
+	for _, problem := range problems {
+		fmt.Println("ERROR:", problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	if !silent {
+		fmt.Println("Config OK:", strings.Join(configFiles(), ", "))
+	}
+}
+
+// schemaCommand is snitchit config schema, which prints the JSON Schema of the config or writes it to --output
+func schemaCommand() {
+	out, err := json.MarshalIndent(rootSchema(), "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: Cannot generate schema:", err)
+		os.Exit(1)
+	}
+	out = append(out, '\n')
+
+	if output := viper.GetString("output"); output != "" {
+		if err := ioutil.WriteFile(output, out, 0644); err != nil {
+			fmt.Println("ERROR: Cannot write schema:", err)
+			os.Exit(1)
+		}
+		if !silent {
+			fmt.Println("Wrote schema to", output)
+		}
+		return
+	}
+	fmt.Print(string(out))
+}
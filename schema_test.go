@@ -0,0 +1,93 @@
+package main
+
+// schema_test.go
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintValue(t *testing.T) {
+	tests := []struct {
+		config   string
+		problems []string
+	}{
+		{"", nil},
+		{"apikey: abc\ndefaultsnitch: 10ffbf9437f6\nplan: small\nalert: basic\ninterval: daily\n", nil},
+		{"silent: true\nlog-keep: 5\ntimeout: 2h\n", nil},
+		{"defualtsnitch: abc\n", []string{"defualtsnitch is not a known setting, did you mean defaultsnitch?"}},
+		{"silent: yes please\n", []string{"silent should be true or false, not yes please"}},
+		{"log-keep: many\n", []string{"log-keep should be a whole number, not many"}},
+		{"alert: loud\n", []string{`alert: "loud" should be "basic" or "smart"`}},
+		{"timeout: soon\n", []string{`timeout: time: invalid duration "soon"`}},
+		{"apikey: [a, b]\n", []string{"apikey should be a single value"}},
+		{"smtp: mail.example.com\n", []string{"smtp should be a map"}},
+		{"snitches: abc\n", []string{"snitches should be a list"}},
+		{"snitches: [abc, def, abc]\n", []string{"snitches[2] abc is listed more than once"}},
+		{"aliases:\n  db: abc\n  DB: def\n", []string{"aliases.db is set more than once"}},
+		{"jobs:\n  backup:\n    command: /bin/true\n    schedule: every day\n", []string{`jobs.backup.schedule: expected 5 fields in schedule "every day", found 2`}},
+		{"jobs:\n  backup:\n    comand: /bin/true\n", []string{"jobs.backup.comand is not a known setting, did you mean command?"}},
+		{"profiles:\n  prod:\n    interval: often\n", []string{`profiles.prod.interval: "often" should be one of 15_minute, 30_minute, hourly, daily, weekly, monthly`}},
+		{"redact: ['(unclosed']\n", []string{"redact[0]: error parsing regexp: missing closing ): `(unclosed`"}},
+		// an empty setting is the same as one not set
+		{"smtp:\n", nil},
+	}
+	for _, test := range tests {
+		var config yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(test.config), &config); err != nil {
+			t.Fatalf("%q: %v", test.config, err)
+		}
+		var value interface{}
+		if config != nil {
+			value = config
+		}
+		if got := lintValue("", value, rootSchema()); !reflect.DeepEqual(got, test.problems) {
+			t.Errorf("lintValue(%q) = %q, want %q", test.config, got, test.problems)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	os.MkdirAll(filepath.Join(dir, "xdg", "snitchit"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "xdg", "snitchit", "config.yaml"), []byte("plan: free\nalert: basic\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".snitchit.yaml"), []byte("alert: smart\ndefualtsnitch: abc\nprofiles:\n  paid:\n    plan: large\n    interval: hourly\n  cheap:\n    plan: small\n    interval: daily\n"), 0644)
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+	if previous, ok := os.LookupEnv("XDG_CONFIG_HOME"); ok {
+		defer os.Setenv("XDG_CONFIG_HOME", previous)
+	} else {
+		defer os.Unsetenv("XDG_CONFIG_HOME")
+	}
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+
+	// problems name the file they are in, plans are checked against the layered config and each profile
+	var problems []string
+	for _, problem := range lintConfig() {
+		if !strings.HasPrefix(problem, "/etc/") {
+			problems = append(problems, problem)
+		}
+	}
+	want := []string{
+		filepath.Join(dir, ".snitchit.yaml") + ": defualtsnitch is not a known setting, did you mean defaultsnitch?",
+		"the free plan does not allow smart alerts",
+		"profile cheap: the small plan does not allow smart alerts for daily snitches",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("lintConfig() = %q, want %q", problems, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+// secret.go
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// secretKeys are the settings which hold secrets, they can be references resolved by resolveSecret
+var secretKeys = []string{"apikey", "smtp.password", "relay.secret", "receive.secret"}
+
+var (
+	secretsmu sync.Mutex
+	secrets   []string
+)
+
+// resolveSecret turns a reference such as "env:DMS_API_KEY", "file:/run/secrets/dms", "exec:pass show dms"
+// or "keyring:dms" in to the secret it refers to, anything else is returned as it is
+func resolveSecret(value string) (string, error) {
+	var secret string
+
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		var ok bool
+		if secret, ok = os.LookupEnv(name); !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(value, "file:"):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		secret = string(data)
+	case strings.HasPrefix(value, "exec:"):
+		data, err := exec.Command("/bin/sh", "-c", strings.TrimPrefix(value, "exec:")).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", strings.TrimPrefix(value, "exec:"), err)
+		}
+		secret = string(data)
+	case strings.HasPrefix(value, "keyring:"):
+		var err error
+		if secret, err = keyringLookup(strings.TrimPrefix(value, "keyring:")); err != nil {
+			return "", err
+		}
+	default:
+		secret = value
+	}
+
+	// secrets from files and commands usually end with a newline
+	secret = strings.TrimRight(secret, "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", value)
+	}
+
+	addSecret(secret)
+	return secret, nil
+}
+
+// keyringLookup reads a secret from the system keyring, such as gnome-keyring or KeePassXC, through the Secret Service D-Bus API.
+// "keyring:dms" looks up service=snitchit account=dms, "keyring:service=dms,account=prod" looks up the attributes given.
+func keyringLookup(ref string) (string, error) {
+	attributes := map[string]string{"service": "snitchit", "account": ref}
+	if strings.Contains(ref, "=") {
+		attributes = make(map[string]string)
+		for _, attribute := range strings.Split(ref, ",") {
+			parts := strings.SplitN(attribute, "=", 2)
+			if len(parts) != 2 {
+				return "", fmt.Errorf("invalid keyring attribute %q", attribute)
+			}
+			attributes[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	bus, err := dialDBus(os.Getenv("DBUS_SESSION_BUS_ADDRESS"))
+	if err != nil {
+		return "", fmt.Errorf("keyring lookup %s: %s", ref, err)
+	}
+	defer bus.close()
+	secret, err := secretServiceLookup(bus, attributes)
+	if err != nil {
+		return "", fmt.Errorf("keyring lookup %s: %s", ref, err)
+	}
+	return secret, nil
+}
+
+// secretServiceLookup finds the first unlocked item with the attributes and reads its secret
+func secretServiceLookup(bus *dbusConn, attributes map[string]string) (string, error) {
+	const (
+		destination = "org.freedesktop.secrets"
+		service     = "org.freedesktop.Secret.Service"
+		root        = dbusObjectPath("/org/freedesktop/secrets")
+	)
+
+	// the plain algorithm sends the secret unencrypted, over a socket only this user can connect to
+	reply, err := bus.call(destination, root, service, "OpenSession", "sv", "plain", dbusVariant{"s", ""})
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("unexpected reply to OpenSession")
+	}
+	session, ok := reply[1].(dbusObjectPath)
+	if !ok {
+		return "", fmt.Errorf("unexpected reply to OpenSession")
+	}
+	defer bus.call(destination, session, "org.freedesktop.Secret.Session", "Close", "")
+
+	reply, err = bus.call(destination, root, service, "SearchItems", "a{ss}", attributes)
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("unexpected reply to SearchItems")
+	}
+	unlocked, _ := reply[0].([]interface{})
+	locked, _ := reply[1].([]interface{})
+	if len(unlocked) == 0 {
+		if len(locked) > 0 {
+			return "", fmt.Errorf("the keyring holding it is locked, unlock it and try again")
+		}
+		return "", fmt.Errorf("no secret with these attributes in the keyring")
+	}
+	item, ok := unlocked[0].(dbusObjectPath)
+	if !ok {
+		return "", fmt.Errorf("unexpected reply to SearchItems")
+	}
+
+	// a secret is the struct (session, parameters, value, content type)
+	reply, err = bus.call(destination, item, "org.freedesktop.Secret.Item", "GetSecret", "o", session)
+	if err != nil {
+		return "", err
+	}
+	if len(reply) == 1 {
+		if fields, ok := reply[0].([]interface{}); ok && len(fields) == 4 {
+			if value, ok := fields[2].([]byte); ok {
+				return string(value), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unexpected reply to GetSecret")
+}
+
+// secretSetting returns the resolved value of a secret setting, exiting when its reference cannot be resolved
+func secretSetting(key string) string {
+	value := viper.GetString(key)
+	if value == "" {
+		return ""
+	}
+	secret, err := resolveSecret(value)
+	if err != nil {
+		fmt.Println("ERROR: Cannot read", key, ":", err)
+		os.Exit(1)
+	}
+	return secret
+}
+
+// isSecretRef reports whether value is a reference to a secret rather than the secret itself
+func isSecretRef(value string) bool {
+	for _, prefix := range []string{"env:", "file:", "exec:", "keyring:"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addSecret remembers a secret so redactSecrets can hide it, including as it appears in basic auth headers
+func addSecret(secret string) {
+	secretsmu.Lock()
+	defer secretsmu.Unlock()
+	secrets = append(secrets, secret, base64.StdEncoding.EncodeToString([]byte(secret+":")))
+}
+
+// redactSecrets replaces every secret snitchit has read with [REDACTED]
+func redactSecrets(text string) string {
+	secretsmu.Lock()
+	defer secretsmu.Unlock()
+	for _, secret := range secrets {
+		if len(secret) >= 4 {
+			text = strings.Replace(text, secret, "[REDACTED]", -1)
+		}
+	}
+	return text
+}
+
+// redactingWriter redacts secrets from everything written through it, used for the log
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactSettings hides secret values in settings for display, leaving references such as "env:DMS_API_KEY" readable
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{})
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			redacted[key] = redactSettings(v)
+		case map[interface{}]interface{}:
+			converted := make(map[string]interface{})
+			for k, value := range v {
+				converted[fmt.Sprint(k)] = value
+			}
+			redacted[key] = redactSettings(converted)
+		case string:
+			redacted[key] = v
+			if isSecretKey(key) && v != "" && !isSecretRef(v) {
+				redacted[key] = "[REDACTED]"
+			}
+		default:
+			redacted[key] = v
+		}
+	}
+	return redacted
+}
+
+func isSecretKey(key string) bool {
+	for _, secret := range secretKeys {
+		parts := strings.Split(secret, ".")
+		if strings.ToLower(key) == parts[len(parts)-1] {
+			return true
+		}
+	}
+	return false
+}
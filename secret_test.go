@@ -0,0 +1,142 @@
+package main
+
+// secret_test.go
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecretKeyring(t *testing.T) {
+	calls, cleanup := fakeSecretService(t, map[dbusObjectPath]fakeItem{
+		"/org/freedesktop/secrets/collection/login/1": {attributes: map[string]string{"service": "snitchit", "account": "dms"}, secret: "dmskey"},
+		"/org/freedesktop/secrets/collection/login/2": {attributes: map[string]string{"service": "dms", "account": "prod"}, secret: "prodkey\n"},
+		"/org/freedesktop/secrets/collection/work/1":  {attributes: map[string]string{"service": "snitchit", "account": "work"}, secret: "workkey", locked: true},
+	})
+	defer cleanup()
+
+	tests := []struct {
+		ref    string
+		secret string
+		err    string
+	}{
+		{"keyring:dms", "dmskey", ""},
+		{"keyring:service=dms, account=prod", "prodkey", ""},
+		{"keyring:missing", "", "keyring lookup missing: no secret with these attributes in the keyring"},
+		{"keyring:work", "", "keyring lookup work: the keyring holding it is locked, unlock it and try again"},
+		{"keyring:service=dms,prod", "", `invalid keyring attribute "prod"`},
+	}
+	for _, test := range tests {
+		secret, err := resolveSecret(test.ref)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("resolveSecret(%q) = %q, %v, want the error %q", test.ref, secret, err, test.err)
+			}
+		} else if err != nil || secret != test.secret {
+			t.Errorf("resolveSecret(%q) = %q, %v, want %q", test.ref, secret, err, test.secret)
+		}
+	}
+
+	// each lookup opens a plain session, searches, reads the secret it found and closes the session
+	want := []string{
+		"Hello /org/freedesktop/DBus",
+		"OpenSession /org/freedesktop/secrets",
+		"SearchItems /org/freedesktop/secrets",
+		"GetSecret /org/freedesktop/secrets/collection/login/1",
+		"Close /org/freedesktop/secrets/session/1",
+	}
+	if got := calls(); len(got) < len(want) || !equalStrings(got[:len(want)], want) {
+		t.Errorf("calls = %q, want them to start %q", got, want)
+	}
+
+	os.Unsetenv("DBUS_SESSION_BUS_ADDRESS")
+	if _, err := resolveSecret("keyring:dms"); err == nil || !strings.Contains(err.Error(), "DBUS_SESSION_BUS_ADDRESS is not set") {
+		t.Errorf("resolveSecret without a session bus = %v, want DBUS_SESSION_BUS_ADDRESS is not set", err)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	os.Setenv("SNITCHIT_TEST_SECRET", "envkey")
+	defer os.Unsetenv("SNITCHIT_TEST_SECRET")
+
+	file, err := ioutil.TempFile("", "snitchit-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("filekey\n")
+	file.Close()
+
+	tests := []struct {
+		ref    string
+		secret string
+		fails  bool
+	}{
+		{"plainkey", "plainkey", false},
+		{"env:SNITCHIT_TEST_SECRET", "envkey", false},
+		{"env:SNITCHIT_TEST_UNSET", "", true},
+		{"file:" + file.Name(), "filekey", false},
+		{"file:/nonexistent/snitchit", "", true},
+		{"exec:echo execkey", "execkey", false},
+		{"exec:true", "", true},
+		{"exec:exit 1", "", true},
+	}
+	for _, test := range tests {
+		secret, err := resolveSecret(test.ref)
+		if test.fails {
+			if err == nil {
+				t.Errorf("resolveSecret(%q) = %q, want an error", test.ref, secret)
+			}
+			continue
+		}
+		if err != nil || secret != test.secret {
+			t.Errorf("resolveSecret(%q) = %q, %v, want %q", test.ref, secret, err, test.secret)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+
+	secret, err := resolveSecret("exec:echo hunter2hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(secret + ":"))
+
+	if got, want := redactSecrets("key "+secret+" auth Basic "+auth), "key [REDACTED] auth Basic [REDACTED]"; got != want {
+		t.Errorf("redactSecrets = %q, want %q", got, want)
+	}
+
+	var log bytes.Buffer
+	redactingWriter{&log}.Write([]byte("using " + secret + "\n"))
+	if got, want := log.String(), "using [REDACTED]\n"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+
+	// a job printing a secret snitchit read does not leak it in to the check in
+	viper.Set("output-lines", 1)
+	defer viper.Set("output-lines", nil)
+	runJob(job{Name: "leaky", Snitch: "abc", Command: "echo connecting with " + secret + "; exit 1"})
+	if checkins := received(); len(checkins) != 1 || strings.Contains(checkins[0], secret) || !strings.Contains(checkins[0], "connecting with [REDACTED]") {
+		t.Errorf("check ins = %q, want the secret redacted", checkins)
+	}
+
+	settings := redactSettings(map[string]interface{}{
+		"apikey": "plainkey",
+		"smtp":   map[interface{}]interface{}{"password": "env:SMTP_PASSWORD", "username": "snitchit"},
+		"snitch": "abc",
+	})
+	if settings["apikey"] != "[REDACTED]" || settings["snitch"] != "abc" {
+		t.Errorf("redactSettings = %v, want apikey redacted", settings)
+	}
+	if smtp := settings["smtp"].(map[string]interface{}); smtp["password"] != "env:SMTP_PASSWORD" || smtp["username"] != "snitchit" {
+		t.Errorf("redactSettings smtp = %v, want references left readable", smtp)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+// snippet.go
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// snippetData is what snippet templates can use
+type snippetData struct {
+	Token      string
+	Name       string
+	Slug       string
+	CheckInURL string
+	Interval   string
+	Schedule   string
+	Every      string
+}
+
+// intervalSchedules are cron schedules which check in once per snitch interval
+var intervalSchedules = map[string]string{
+	"15_minute": "*/15 * * * *",
+	"30_minute": "*/30 * * * *",
+	"hourly":    "0 * * * *",
+	"daily":     "0 0 * * *",
+	"weekly":    "0 0 * * 0",
+	"monthly":   "0 0 1 * *",
+}
+
+var snippetTemplates = map[string]string{
+	"curl": `# check in to {{.Name}}
+curl -fsS --retry 3 --max-time 15 -o /dev/null "{{.CheckInURL}}"
+
+# or run a command and check in with its exit status
+your-command; curl -fsS --retry 3 --max-time 15 -o /dev/null "{{.CheckInURL}}?s=$?"
+`,
+	"wget": `# check in to {{.Name}}
+wget -q -O /dev/null -T 15 -t 3 "{{.CheckInURL}}"
+
+# or run a command and check in with its exit status
+your-command; wget -q -O /dev/null -T 15 -t 3 "{{.CheckInURL}}?s=$?"
+`,
+	"cron": `# {{.Name}}, checks in {{.Interval}}
+{{.Schedule}} your-command; curl -fsS --retry 3 --max-time 15 -o /dev/null "{{.CheckInURL}}?s=$?"
+`,
+	"k8s-cronjob": `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: {{.Slug}}
+spec:
+  schedule: "{{.Schedule}}"
+  concurrencyPolicy: Forbid
+  jobTemplate:
+    spec:
+      backoffLimit: 0
+      template:
+        spec:
+          restartPolicy: Never
+          containers:
+            - name: {{.Slug}}
+              image: alpine:3
+              command:
+                - /bin/sh
+                - -c
+                - your-command; wget -q -O /dev/null -T 15 -t 3 "{{.CheckInURL}}?s=$?"
+`,
+	"dockerfile-healthcheck": `# checks in while the container is healthy, {{.Name}} alerts when it is not
+HEALTHCHECK --interval={{.Every}} --timeout=15s CMD your-command && wget -q -O /dev/null -T 15 "{{.CheckInURL}}" || exit 1
+`,
+	"github-actions": `name: {{.Name}}
+on:
+  schedule:
+    - cron: "{{.Schedule}}"
+  workflow_dispatch:
+jobs:
+  run:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - run: your-command
+      - name: Check in to deadmanssnitch.com
+        if: always()
+        run: curl -fsS --retry 3 --max-time 15 -o /dev/null "{{.CheckInURL}}?s={{"${{ job.status == 'success' && '0' || '1' }}"}}"
+`,
+}
+
+func snippet() {
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	format := strings.ToLower(viper.GetString("format"))
+	text, ok := snippetTemplates[format]
+	if !ok {
+		fmt.Println("ERROR: Invalid format", format, ". Please choose either \"curl\", \"wget\", \"cron\", \"k8s-cronjob\", \"dockerfile-healthcheck\" or \"github-actions\"")
+		os.Exit(1)
+	}
+
+	// a template in --templates replaces the built in one
+	if dir := viper.GetString("templates"); dir != "" {
+		override, err := ioutil.ReadFile(filepath.Join(dir, format+".tmpl"))
+		if err == nil {
+			text = string(override)
+		} else if !os.IsNotExist(err) {
+			fmt.Println("ERROR: Cannot read template:", err)
+			os.Exit(1)
+		}
+	}
+
+	tmpl, err := template.New(format).Parse(text)
+	if err != nil {
+		fmt.Println("ERROR: Invalid template for", format, ":", err)
+		os.Exit(1)
+	}
+
+	found, err := findSnitch(snitch)
+	if err != nil {
+		fmt.Println("ERROR: Cannot find snitch:", err)
+		os.Exit(1)
+	}
+
+	data := snippetData{
+		Token:      found.Token,
+		Name:       found.Name,
+		Slug:       strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(found.Name), "-"), "-"),
+		CheckInURL: found.CheckInURL,
+		Interval:   found.Interval,
+		Schedule:   intervalSchedules[found.Interval],
+		Every:      (intervalPeriod(found.Interval) / 2).String(),
+	}
+	if data.CheckInURL == "" {
+		data.CheckInURL = strings.TrimSuffix(viper.GetString("checkin-url"), "/") + "/" + found.Token
+	}
+	if data.Slug == "" {
+		data.Slug = "snitch-" + strings.ToLower(found.Token)
+	}
+	if data.Schedule == "" {
+		data.Schedule = intervalSchedules["daily"]
+		data.Every = (12 * time.Hour).String()
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		fmt.Println("ERROR: Cannot render", format, "snippet:", err)
+		os.Exit(1)
+	}
+
+	if output := viper.GetString("output"); output != "" {
+		if err := ioutil.WriteFile(output, out.Bytes(), 0644); err != nil {
+			fmt.Println("ERROR: Cannot write", output, ":", err)
+			os.Exit(1)
+		}
+		if !silent {
+			fmt.Println("Wrote", output)
+		}
+		return
+	}
+
+	fmt.Print(out.String())
+}
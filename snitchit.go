@@ -3,7 +3,6 @@ package main
 // snitchit.go
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,7 +14,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -33,6 +31,7 @@ type oneSnitch struct {
 	CreatedAt   time.Time `json:"created_at,omitempty"`
 	Interval    string    `json:"interval,omitempty"`
 	AlertType   string    `json:"alert_type,omitempty"`
+	CheckInURL  string    `json:"check_in_url,omitempty"`
 }
 
 type newSnitch struct {
@@ -60,6 +59,8 @@ const appversion = "0.0.18"
 
 var (
 	apikey        string
+	apiurl        string
+	command       string
 	defaultsnitch string
 	interval      string
 	message       string
@@ -71,35 +72,109 @@ var (
 )
 
 func init() {
+	// nothing logged should ever show a secret
+	log.SetOutput(redactingWriter{os.Stderr})
+
+	// every setting can also come from a SNITCHIT_ environment variable, "--in-place" is SNITCHIT_IN_PLACE
 	viper.SetEnvPrefix("SNITCHIT")
-	viper.BindEnv("config")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
 
+	flag.String("action", "", "Actions to show with audit query, \"create,update,tag,delete,pause,unpause\"")
+	flag.String("address", "", "Address to probe, \"host:port\"")
 	flag.String("alert", "basic", "Alert type: \"basic\" or \"smart\"")
+	flag.Bool("all", false, "Check in to every snitch in the snitches list of the config")
+	flag.Bool("all-profiles", false, "Show snitches from every profile in the config")
 	flag.String("apikey", "", "Deadmanssnitch.com API Key")
-	flag.String("config", "config.yaml", "Configuration file: /path/to/file.yaml, default = ./config.yaml")
+	flag.String("apiurl", "https://api.deadmanssnitch.com/v1", "Base URL of the deadmanssnitch.com API")
+	flag.String("checkin-url", "https://nosnch.in", "Base URL check ins are sent to")
+	flag.String("config", "config.yaml", "Configuration file layered over any found, default = ./config.yaml")
+	flag.Bool("configured", false, "Show only the snitches in the snitches list of the config")
 	flag.Bool("create", false, "Create snitch, requires --name and --interval, optional --tags & --notes")
+	flag.Duration("cert-expiry", 0, "Fail a probe when its certificate expires within this long, \"336h\"")
+	flag.Duration("check-every", 30*time.Second, "How often heartbeat checks its health conditions")
 	flag.String("delete", "", "Delete a snitch")
+	flag.String("dir", "/etc/systemd/system", "Directory of systemd units to audit")
 	flag.Bool("displayconfig", false, "Display configuration")
+	flag.Duration("every", 0, "How often to check in, \"10m\"")
+	flag.String("expect-answer", "", "Regex one of the dns probe answers must match")
+	flag.String("expect-body", "", "Regex the http probe response body must match")
+	flag.Int("expect-status", 0, "Status code the http probe must return, default = any 2xx")
+	flag.String("fail-match", "", "Regex for log lines which send an errored check in")
+	flag.String("file", "", "Log file to follow")
+	flag.Bool("force", false, "Check in even when --min-gap would suppress it")
+	flag.String("format", "curl", "Snippet format: \"curl\", \"wget\", \"cron\", \"k8s-cronjob\", \"dockerfile-healthcheck\" or \"github-actions\"")
+	flag.String("health-cmd", "", "Command which must exit 0 for heartbeat to keep checking in")
+	flag.String("health-url", "", "URL which must return 2xx for heartbeat to keep checking in")
 	flag.Bool("help", false, "Display help")
+	flag.Bool("in-place", false, "Rewrite the imported crontab in place, keeping a backup")
 	flag.String("interval", "", "\"15_minute\", \"30_minute\", \"hourly\", \"daily\", \"weekly\", or \"monthly\"")
-	tempmessage := flag.String("message", "", "Mesage to send, default = \"2006-01-02T15:04:05Z07:00\" format")
+	flag.String("listen", "", "Address to listen on, \"host:port\"")
+	flag.String("lease-dir", "", "Shared directory for leases so only one host in a fleet runs a job")
+	flag.Duration("lease-ttl", 0, "How long a lease lasts without renewal, longer than the job runs and shorter than its schedule")
+	flag.String("lock", "", "Lock file so only one copy of a job runs at once")
+	flag.Duration("lock-wait", 0, "How long to wait for the lock before skipping the run")
+	flag.String("log-dir", "", "Directory job output is logged to, default = [state-dir]/logs")
+	flag.Int("log-keep", 10, "Number of output logs to keep for each job")
+	flag.String("match", "", "Regex for log lines which send a check in")
+	flag.Duration("max-age", 0, "Maximum age of a watched file, \"26h\"")
+	flag.String("message", "", "Message to send, a template which can use {{.Hostname}}, {{.ExitCode}} and more, default = \"2006-01-02T15:04:05Z07:00\" format")
+	flag.String("message-file", "", "File to read the message template from, \"-\" for stdin")
+	flag.Duration("max-duration", 0, "Send an errored check in when a job succeeds but runs for longer than this")
+	flag.Duration("min-gap", 0, "Suppress successful check ins for a snitch within this long of the last one, \"10m\"")
+	flag.Duration("min-duration", 0, "Send an errored check in when a job succeeds but runs for less than this")
+	flag.String("min-size", "", "Minimum size of a watched file, \"1MB\"")
 	flag.String("name", "", "Name of snitch")
 	flag.String("notes", "", "Notes")
+	flag.Bool("only-if-due", false, "Only run the job when its snitch has no successful check in for the current period")
+	flag.Bool("on-success", false, "Check in from a unit started by OnSuccess= and OnFailure= instead of ExecStopPost=, needs systemd 249")
+	flag.Bool("once", false, "Check once and exit instead of watching")
+	flag.Bool("origin", false, "Show where each setting came from with --displayconfig")
+	flag.String("output", "", "File or directory to write output to")
+	flag.Int("output-lines", 5, "Number of lines of output to add to the check in when a job fails")
+	flag.String("path", "", "Files to watch, \"/backups/*.tar.gz\"")
 	flag.String("pause", "", "Pause a snitch")
+	flag.Int("pid", 0, "PID which must be alive for heartbeat to keep checking in")
+	flag.String("pidfile", "", "PID file naming a process which must be alive for heartbeat to keep checking in")
 	flag.String("plan", "free", "Plan type: \"free\", \"small\", \"medium\" or \"large\", default = free")
+	flag.String("profile", "", "Profile from the config to use, default = profile from config.yaml")
+	flag.String("query", "", "Name to look up with the dns probe")
+	flag.String("record-type", "A", "Record type for the dns probe: \"A\", \"AAAA\", \"CNAME\", \"MX\", \"NS\" or \"TXT\"")
+	flag.String("resolver", "", "DNS server for the dns probe, \"host:port\", default = system resolver")
 	showsnitches = *flag.Bool("show", false, "Show snitches")
+	flag.Bool("show-secrets", false, "Show secret settings with config get")
+	flag.String("save-as", "", "Alias to save the token of a created snitch as in the config file")
+	flag.Duration("settle", 5*time.Second, "How long a watched file must be unchanged before it is checked")
+	flag.String("since", "", "Show audit log entries since this long ago, date or time, \"24h\" or \"2006-01-02\"")
 	flag.Bool("silent", false, "Be silent")
 	flag.String("snitch", "", "Snitch to use")
+	flag.Duration("splay", 0, "Random delay of up to this long added to each heartbeat, \"30s\"")
+	flag.String("state-dir", "", "Directory for local state, default = /var/lib/snitchit for root, ~/.snitchit for others")
+	flag.Bool("system", false, "Crontab has a user field, default = true for /etc/crontab and /etc/cron.d")
 	flag.String("tags", "", "Tags separated by commas, \"tag1,tag2,tag3\"")
+	flag.String("templates", "", "Directory of snippet templates, [format].tmpl overrides the built in template")
+	flag.Duration("timeout", 0, "Timeout for health checks, probes and jobs")
+	flag.Bool("tls", false, "Perform a TLS handshake in the tcp probe")
+	flag.String("transport", "", "Check in transports to try in order, \"https,smtp\", default = https")
+	flag.String("unit", "", "Systemd unit, \"backup.service\"")
 	flag.String("unpause", "", "Unpause a snitch")
+	flag.String("until", "", "Show audit log entries until this long ago, date or time, \"1h\" or \"2006-01-02T15:04:05Z\"")
 	flag.String("update", "", "Update a snitch, can be used with --name, --interval, --tags & --notes")
 	flag.Bool("verbose", false, "Be verbose")
+	flag.String("url", "", "URL for the http probe")
 	flag.Bool("version", false, "Version")
+	flag.Duration("window", time.Minute, "Send at most one check in per window for matching log lines")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+}
+
+// setup reads the command line and the config, it runs from main rather than init so the tests can load the package
+func setup() {
 	pflag.Parse()
 	viper.BindPFlags(pflag.CommandLine)
 
+	command = strings.ToLower(pflag.Arg(0))
+
 	if viper.GetBool("help") {
 		displayHelp()
 		os.Exit(0)
@@ -110,27 +185,9 @@ func init() {
 		os.Exit(0)
 	}
 
-	configdir, configfile := filepath.Split(viper.GetString("config"))
-
-	// set default configuration directory to current directory
-	if configdir == "" {
-		configdir = "."
-	}
-
-	if viper.GetBool("verbose") {
-		fmt.Println("   DIR:", configdir)
-		fmt.Println("  FILE:", configfile)
-	}
-
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(configdir)
-
-	config := strings.TrimSuffix(configfile, ".yaml")
-	config = strings.TrimSuffix(config, ".yml")
-
-	viper.SetConfigName(config)
-	err := viper.ReadInConfig()
-	if err != nil {
+	err := loadConfig()
+	// config commands can create the config file
+	if err != nil && command != "config" {
 		if !viper.GetBool("silent") {
 			fmt.Println("ERROR: No config file found")
 			if viper.GetBool("verbose") {
@@ -140,44 +197,102 @@ func init() {
 		}
 	}
 
-	if *tempmessage == "" {
-		message = time.Now().Format(time.RFC3339)
-	} else {
-		message = *tempmessage
-	}
-
-	if viper.GetString("snitch") == "" {
-		snitch = viper.GetString("defaultsnitch")
-	} else {
-		snitch = viper.GetString("snitch")
+	// config commands are how a broken config gets fixed, so only they run with one
+	if err == nil && command != "config" {
+		if problems := lintConfig(); len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Println("ERROR: Invalid config:", problem)
+			}
+			fmt.Println("Run \"snitchit config lint\" to check the config")
+			os.Exit(1)
+		}
 	}
 
-	if viper.GetString("alert") != "" {
-		if !checkAlertType(strings.ToLower(viper.GetString("alert"))) {
-			fmt.Println("ERROR: Invalid Alert Type", strings.ToLower(viper.GetString("alert")), ". Please choose either \"basic\" or \"smart\"")
+	// a profile overrides the top level of the config file, flags and environment variables still win
+	if profile := viper.GetString("profile"); profile != "" {
+		if !viper.IsSet("profiles." + profile) {
+			fmt.Println("ERROR: No profile", profile, "in config")
 			os.Exit(1)
 		}
+		if sub := viper.Sub("profiles." + profile); sub != nil {
+			recordOrigin(sub.AllKeys(), "profile "+profile)
+		}
+		viper.MergeConfigMap(viper.GetStringMap("profiles." + profile))
+		if viper.GetBool("verbose") {
+			fmt.Println("PROFILE:", profile)
+		}
+	}
+
+	if viper.GetString("snitch") == "" {
+		snitch = resolveSnitch(viper.GetString("defaultsnitch"))
 	} else {
-		fmt.Println("init: alert check")
+		snitch = resolveSnitch(viper.GetString("snitch"))
 	}
 
-	if viper.GetString("interval") != "" {
-		if !checkInterval(strings.ToLower(viper.GetString("interval"))) {
-			fmt.Println("ERROR: Invalid Interval", strings.ToLower(viper.GetString("interval")), ". Please choose either \"15_minute\", \"30_minute\", \"hourly\", \"daily\", \"weekly\", or \"monthly\"")
-			os.Exit(1)
-		}
+	if err := compileRedactions(); err != nil {
+		fmt.Println("ERROR: Invalid config:", err)
+		os.Exit(1)
 	}
 
-	if !checkPlan(viper.GetString("plan"), viper.GetString("alert"), viper.GetString("interval")) {
-		fmt.Println("ERROR: Basic Alerts are available for any snitch. Smart Alerts are available for hourly, daily, weekly, and monthly interval snitches on the Surveillance Van plan, and for monthly interval snitches on all other plans.")
+	if err := loadMessageTemplate(); err != nil {
+		fmt.Println("ERROR: Invalid message:", err)
 		os.Exit(1)
 	}
 
+	message = customMessage(messageFacts{Snitch: snitch, Message: time.Now().Format(time.RFC3339)})
+	if message == "" {
+		message = time.Now().Format(time.RFC3339)
+	}
+
+	// config lint reports these along with everything else wrong with the config
+	if command != "config" {
+		if viper.GetString("alert") != "" {
+			if !checkAlertType(strings.ToLower(viper.GetString("alert"))) {
+				fmt.Println("ERROR: Invalid Alert Type", strings.ToLower(viper.GetString("alert")), ". Please choose either \"basic\" or \"smart\"")
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println("init: alert check")
+		}
+
+		if viper.GetString("interval") != "" {
+			if !checkInterval(strings.ToLower(viper.GetString("interval"))) {
+				fmt.Println("ERROR: Invalid Interval", strings.ToLower(viper.GetString("interval")), ". Please choose either \"15_minute\", \"30_minute\", \"hourly\", \"daily\", \"weekly\", or \"monthly\"")
+				os.Exit(1)
+			}
+		}
+
+		for _, transport := range checkInTransports() {
+			if !checkTransport(transport) {
+				fmt.Println("ERROR: Invalid Transport", transport, ". Please choose either \"https\" or \"smtp\"")
+				os.Exit(1)
+			}
+		}
+
+		if !checkPlan(viper.GetString("plan"), viper.GetString("alert"), viper.GetString("interval")) {
+			fmt.Println("ERROR:", planProblem(viper.GetString("plan"), viper.GetString("alert"), viper.GetString("interval"))+`, see "snitchit plan-info"`)
+			os.Exit(1)
+		}
+	}
+
+	// references such as "exec:pass show dms" are only resolved when the key will be used, never to display the config
 	apikey = viper.GetString("apikey")
+	unresolved := isSecretRef(apikey) && (!usesAPIKey(command) || viper.GetBool("displayconfig"))
+	if unresolved {
+		apikey = ""
+	} else {
+		apikey = secretSetting("apikey")
+	}
+	for _, key := range secretKeys {
+		if value := viper.GetString(key); value != "" && !isSecretRef(value) {
+			addSecret(value)
+		}
+	}
+	apiurl = strings.TrimSuffix(viper.GetString("apiurl"), "/")
 	silent = viper.GetBool("silent")
 	verbose = viper.GetBool("verbose")
 
-	if len(apikey) == 0 {
+	if len(apikey) == 0 && needsAPIKey(command) && !unresolved {
 		fmt.Println("ERROR: No API Key provided")
 		os.Exit(1)
 	}
@@ -185,12 +300,78 @@ func init() {
 }
 
 func main() {
+	setup()
 
 	if viper.GetBool("displayconfig") {
 		displayConfig()
 		os.Exit(0)
 	}
 
+	switch command {
+	case "":
+		// no command, fall through to the flag driven actions below
+	case "audit":
+		auditCommand()
+		os.Exit(0)
+	case "checkin":
+		checkInCommand()
+		os.Exit(0)
+	case "config":
+		configCommand()
+		os.Exit(0)
+	case "cron":
+		cronCommand()
+		os.Exit(0)
+	case "heartbeat":
+		heartbeat()
+		os.Exit(0)
+	case "plan-info":
+		planInfo()
+		os.Exit(0)
+	case "probe":
+		probe()
+		os.Exit(0)
+	case "receive":
+		receiveWebhooks()
+		os.Exit(0)
+	case "relay":
+		relayCheckIns()
+		os.Exit(0)
+	case "run":
+		runCommand()
+		os.Exit(0)
+	case "scheduler":
+		scheduler()
+		os.Exit(0)
+	case "show":
+		if viper.GetBool("all-profiles") {
+			showAllProfiles()
+		} else if viper.GetBool("configured") {
+			showConfigured()
+		} else {
+			displaySnitch(snitch)
+		}
+		os.Exit(0)
+	case "snippet":
+		snippet()
+		os.Exit(0)
+	case "stats":
+		jobStats()
+		os.Exit(0)
+	case "systemd":
+		systemdCommand()
+		os.Exit(0)
+	case "tail":
+		tailFile()
+		os.Exit(0)
+	case "watch-file":
+		watchFile()
+		os.Exit(0)
+	default:
+		fmt.Println("ERROR: Unknown command", command)
+		os.Exit(1)
+	}
+
 	if viper.GetBool("show") {
 		displaySnitch(snitch)
 		os.Exit(0)
@@ -198,7 +379,7 @@ func main() {
 
 	if viper.GetString("update") != "" {
 		fmt.Println("Updating snitch")
-		updateSnitch(viper.GetString("update"))
+		updateSnitch(resolveSnitch(viper.GetString("update")))
 		os.Exit(0)
 	}
 
@@ -215,18 +396,18 @@ func main() {
 	}
 
 	if viper.GetString("delete") != "" {
-		deleteSnitch(viper.GetString("delete"))
+		deleteSnitch(resolveSnitch(viper.GetString("delete")))
 		os.Exit(0)
 	}
 
 	if viper.GetString("pause") != "" {
-		pauseSnitch(viper.GetString("pause"))
+		pauseSnitch(resolveSnitch(viper.GetString("pause")))
 		os.Exit(0)
 	}
 
 	if viper.GetString("unpause") != "" {
 		message = "Unpausing: " + message
-		unpauseSnitch(viper.GetString("unpause"))
+		unpauseSnitch(resolveSnitch(viper.GetString("unpause")))
 		os.Exit(0)
 	}
 
@@ -244,55 +425,93 @@ func main() {
 
 func displayConfig() {
 	fmt.Println("CONFIG: file :", viper.ConfigFileUsed())
-	allmysettings := viper.AllSettings()
+
+	if viper.GetBool("origin") {
+		settings := redactSettings(viper.AllSettings())
+		keys := viper.AllKeys()
+		sort.Strings(keys)
+		w := new(tabwriter.Writer)
+		// minwidth, tabwidth, padding, padchar, flags
+		w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", "Setting", "Value", "Origin")
+		for _, k := range keys {
+			// look the value up in the redacted settings, following the dotted path
+			var value interface{} = settings
+			for _, part := range strings.Split(k, ".") {
+				if m, ok := value.(map[string]interface{}); ok {
+					value = m[part]
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", k, redactSecrets(fmt.Sprint(value)), settingOrigin(k))
+		}
+		w.Flush()
+		return
+	}
+
+	allmysettings := redactSettings(viper.AllSettings())
 	var keys []string
 	for k := range allmysettings {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		fmt.Println("CONFIG:", k, ":", allmysettings[k])
+		fmt.Println(redactSecrets(fmt.Sprint("CONFIG: ", k, " : ", allmysettings[k])))
 	}
 }
 
 func sendSnitch(sendsnitch string) {
+	if throttled(sendsnitch, "") {
+		if !silent {
+			fmt.Println("Suppressed, the last check in was within --min-gap")
+		}
+		return
+	}
+
+	if err := deliverCheckIn(sendsnitch, message, ""); err != nil {
+		log.Fatalf("sendCheckIn() failed with '%s'\n", err)
+	}
+
+	if !silent {
+		fmt.Println("Success")
+	}
+
+}
+
+// postCheckIn sends a check in for token to checkinurl, status is the optional exit code of the job
+func postCheckIn(checkinurl string, token string, msg string, status string) (int, []byte, error) {
 	client := &http.Client{}
 	client.Timeout = time.Second * 15
-	sendsnitch = url.QueryEscape(sendsnitch)
+	if checkinurl == "" {
+		checkinurl = "https://nosnch.in"
+	}
+	uri := strings.TrimSuffix(checkinurl, "/") + "/" + url.PathEscape(token)
 	if verbose {
-		fmt.Printf("Snitch: https://nosnch.in/%s\n", sendsnitch)
+		fmt.Println("Snitch:", uri)
 	}
-	uri := fmt.Sprintf("https://nosnch.in/%s", sendsnitch)
 	data := url.Values{
-		"m": []string{message},
+		"m": []string{msg},
+	}
+	if status != "" {
+		data.Set("s", status)
 	}
 	resp, err := client.PostForm(uri, data)
 	if err != nil {
-		log.Fatalf("client.PosFormt() failed with '%s'\n", err)
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 
 	snitchresponse, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("ioutil.ReadAll() failed with '%s'\n", err)
-	}
-
-	if verbose {
-		fmt.Println("Response Code:", resp.StatusCode, "Response Text:", http.StatusText(resp.StatusCode), "Message:", snitchresponse)
-	}
-
-	if !silent {
-		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-			fmt.Println("Success")
-		}
+		return resp.StatusCode, nil, err
 	}
 
+	return resp.StatusCode, snitchresponse, nil
 }
 
 func displaySnitch(snitch string) {
 
 	snitch = url.QueryEscape(snitch)
-	url := fmt.Sprintf("https://api.deadmanssnitch.com/v1/snitches/%s", snitch)
+	url := fmt.Sprintf("%s/snitches/%s", apiurl, snitch)
 
 	req, err := http.NewRequest("GET", url, nil)
 	req.SetBasicAuth(apikey, "")
@@ -349,16 +568,30 @@ func displaySnitch(snitch string) {
 
 func pauseSnitch(snitch string) {
 	fmt.Println("Pausing snitch:", snitch)
-	if actionSnitch2("pause", snitch, "") {
+	before := auditSnitch(snitch)
+	_, _, err := apiRequest("POST", "/snitches/"+url.PathEscape(snitch)+"/pause", nil)
+	recordAudit("pause", snitch, before, auditSnitch(snitch), err)
+	if err == nil {
 		fmt.Println("Successfully paused", snitch)
 	} else {
-		fmt.Println("ERROR: Cannot pause snitch", snitch)
+		fmt.Println("ERROR: Cannot pause snitch", snitch, ":", err)
 	}
 }
 
+// unpauseSnitch checks in, which unpauses a paused snitch
 func unpauseSnitch(snitch string) {
 	fmt.Println("Unpausing snitch:", snitch)
-	sendSnitch(snitch)
+	before := auditSnitch(snitch)
+	// unpausing always checks in, --min-gap must not leave the snitch paused
+	err := deliverCheckIn(snitch, message, "")
+	recordAudit("unpause", snitch, before, auditSnitch(snitch), err)
+	if err != nil {
+		log.Fatalf("sendCheckIn() failed with '%s'\n", err)
+	}
+
+	if !silent {
+		fmt.Println("Success")
+	}
 }
 
 func createSnitch(newsnitch newSnitch) {
@@ -383,14 +616,26 @@ func createSnitch(newsnitch newSnitch) {
 	newsnitch.Interval = strings.ToLower(viper.GetString("interval"))
 	newsnitch.AlertType = strings.ToLower(viper.GetString("alert"))
 
+	enforcePlan(newsnitch.AlertType, newsnitch.Interval, true)
+
 	// check if existing snitch exists
 	if !existSnitch(newsnitch) {
 		fmt.Printf("Snitch %s already exists\n", newsnitch)
 	} else {
-		if actionSnitch2("create", "", string(jsonsnitch)) { // error here
-			fmt.Println("Successfully created snitch")
-		} else {
-			fmt.Println("ERROR: Cannot create snitch", newsnitch.Name)
+		created, err := postSnitch(newsnitch)
+		// a failed create has nothing to record as after
+		var after *oneSnitch
+		if err == nil {
+			after = &created
+		}
+		recordAudit("create", created.Token, nil, after, err)
+		if err != nil {
+			fmt.Println("ERROR: Cannot create snitch", newsnitch.Name, ":", err)
+			os.Exit(1)
+		}
+		fmt.Println("Successfully created snitch", created.Token)
+		if alias := viper.GetString("save-as"); alias != "" {
+			saveAlias(alias, created.Token)
 		}
 	}
 
@@ -402,10 +647,13 @@ func deleteSnitch(snitchid string) {
 	//if existSnitch(delsnitch) {
 	if true {
 		fmt.Println("Deleting snitch:", snitchid)
-		if actionSnitch2("delete", snitchid, "") {
+		before := auditSnitch(snitchid)
+		_, _, err := apiRequest("DELETE", "/snitches/"+url.PathEscape(snitchid), nil)
+		recordAudit("delete", snitchid, before, nil, err)
+		if err == nil {
 			fmt.Println("Successfully deleted snitch", snitchid)
 		} else {
-			fmt.Println("ERROR: Cannot delete snitch", snitchid)
+			fmt.Println("ERROR: Cannot delete snitch", snitchid, ":", err)
 		}
 	} else {
 		fmt.Printf("ERROR: Snitch %s not found\n", snitch)
@@ -419,7 +667,7 @@ func existSnitch(snitch newSnitch) bool {
 
 func updateSnitch(snitchtoken string) {
 	snitchtoken = url.QueryEscape(snitchtoken)
-	url := fmt.Sprintf("https://api.deadmanssnitch.com/v1/snitches/%s", snitchtoken)
+	url := fmt.Sprintf("%s/snitches/%s", apiurl, snitchtoken)
 
 	req, err := http.NewRequest("GET", url, nil)
 	req.SetBasicAuth(apikey, "")
@@ -509,24 +757,34 @@ func updateSnitch(snitchtoken string) {
 		updatesnitch.AlertType = foundSnitch.AlertType
 	}
 
-	// generate update json payload
-	jsonudsnitch, err := json.Marshal(updatesnitch)
-
-	if err != nil {
-		fmt.Println("ERROR: Cannot convert to json")
-		os.Exit(1)
-	}
+	enforcePlan(updatesnitch.AlertType, updatesnitch.Interval, false)
 
 	if verbose {
 		fmt.Println("Current Snitch:", foundSnitch)
 		fmt.Println("    New Snitch:", updatesnitch)
 	}
 
-	if actionSnitch2("update", snitchtoken, string(jsonudsnitch)) {
+	// an update which only changes tags is audited as a tag change
+	action := "update"
+	if updatesnitch.Tags != nil && updatesnitch.Name == "" && updatesnitch.Notes == "" && updatesnitch.Interval == foundSnitch.Interval && updatesnitch.AlertType == foundSnitch.AlertType {
+		action = "tag"
+	}
+
+	_, data, err := apiRequest("PATCH", "/snitches/"+snitchtoken, updatesnitch)
+	var updated *oneSnitch
+	if err == nil {
+		updated = &oneSnitch{}
+		if json.Unmarshal(data, updated) != nil {
+			updated = auditSnitch(snitchtoken)
+		}
+	}
+	recordAudit(action, snitchtoken, &foundSnitch, updated, err)
+
+	if err == nil {
 		fmt.Println("Successfully updated snitch")
 		os.Exit(0)
 	} else {
-		fmt.Println("ERROR: Cannot update snitch", snitchtoken)
+		fmt.Println("ERROR: Cannot update snitch", snitchtoken, ":", err)
 		os.Exit(1)
 	}
 }
@@ -549,138 +807,166 @@ func checkInterval(interval string) bool {
 	}
 }
 
-func checkPlan(plan string, alert string, interval string) bool {
-	if strings.ToLower(alert) == "basic" {
-		// all plans allow basic snitches
+func needsAPIKey(command string) bool {
+	if viper.GetBool("all-profiles") {
+		// each profile has its own key
+		return false
+	}
+	switch command {
+	case "audit", "checkin", "config", "heartbeat", "probe", "receive", "relay", "run", "scheduler", "stats", "systemd", "tail", "watch-file":
+		return false
+	default:
 		return true
-	} else {
-		if strings.ToLower(plan) == "free" {
-			// free plans do not allow smart snitches
-			return false
-		}
-		if (strings.ToLower(interval) == "15_minute") || (strings.ToLower(interval) == "30_minute") {
-			return false
-		}
-		if strings.ToLower(plan) == "large" {
-			// large plans can have smart snitches for hourly, daily, weekly or monthly
-			return true
-		}
-		if strings.ToLower(interval) == "monthly" {
-			// small, medium and large allow smart snitches for monthly
-			return true
-		} else {
-			return false
-		}
 	}
 }
 
+// usesAPIKey is true when the command can use the API key, so a secret reference for it needs resolving
+func usesAPIKey(command string) bool {
+	if needsAPIKey(command) || viper.GetBool("only-if-due") {
+		return true
+	}
+	return command == "systemd" && strings.ToLower(pflag.Arg(1)) == "audit"
+}
+
+// checkPlan reports whether plan allows alert for interval, an empty interval means any interval
+func checkPlan(plan string, alert string, interval string) bool {
+	policy, ok := planPolicies()[strings.ToLower(plan)]
+	if !ok {
+		// every plan allows basic alerts
+		policy = planPolicy{Alerts: basicOnly}
+	}
+	return policy.allows(alert, interval)
+}
+
 func displayHelp() {
 	helpmessage := `
-snitchit
-
+snitchit [command]
+
+Commands:
+  audit query                        Show changes made to snitches, filtered by --snitch, --action, --since and --until
+  checkin --snitch [snitch]          Check in, same as running snitchit with no command
+  checkin --all                      Check in to every snitch in the snitches list of the config at once
+  config get [key]                   Print the value of a setting
+  config set [key] [value]           Set a setting in the config file, keeping a backup
+  config unset [key]                 Remove a setting from the config file, keeping a backup
+  config add-snitch [snitch]         Add a snitch to snitches in the config file
+  config lint                        Check the config files for unknown settings, wrong types and invalid values
+  config schema                      Print the JSON Schema of the config, or write it to --output
+  cron import [crontab]              Create snitches for each crontab entry and wrap them with snitchit,
+                                     dry run unless --output or --in-place are given
+  heartbeat --snitch [snitch] --every [duration]
+                                     Check in periodically while --pid, --pidfile, --health-cmd and --health-url are healthy
+  plan-info                          Show what the plan allows and how many more snitches the account can have
+  probe http --snitch [snitch] --url [url]
+  probe tcp --snitch [snitch] --address [host:port]
+  probe dns --snitch [snitch] --query [name]
+                                     Check in when the target is healthy, once or every --every
+  receive --listen [host:port]       Receive deadmanssnitch.com webhooks and run remediation rules from config
+  relay --listen [host:port]         Accept nosnch.in compatible check ins and forward them upstream
+  run [job]                          Run a job from config and check in with its exit status
+  run --snitch [snitch] -- [command]
+                                     Run a command and check in with its exit status
+  scheduler                          Run all jobs from config on their schedules
+  snippet --snitch [snitch] --format [format]
+                                     Print a ready to paste check in for places snitchit is not installed
+  show                               Display all snitches, same as --show
+  show --all-profiles                Display the snitches of every profile in the config
+  show --configured                  Display the snitches in the snitches list of the config, and any missing from it
+  stats [job]                        Summarise the run times of a job
+  systemd generate --unit [unit] --snitch [snitch]
+                                     Write a drop-in which checks in with the service result whenever the unit stops
+  systemd audit --dir [directory]    List timers with no snitch or whose schedule does not suit the snitch interval
+  tail --snitch [snitch] --file [file] --match [regex]
+                                     Check in when a line matching --match is logged, errored check in on --fail-match
+  watch-file --snitch [snitch] --path [pattern]
+                                     Check in when a matching file is written, optional --min-size, --max-age & --once
+
+Options:
+  --action [actions]                 Actions to show with audit query, "create,update,tag,delete,pause,unpause"
+  --address [host:port]              Address for the tcp probe
   --alert [type]                     Alert type: "basic" or "smart"
+  --all                              Check in to every snitch in the snitches list of the config
+  --all-profiles                     Show snitches from every profile in the config
   --apikey [api key]                 Deadmanssnitch.com API key
-  --config [config file]             Configuration file: /path/to/file.yaml, default = ./config.yaml
+  --apiurl [url]                     Base URL of the deadmanssnitch.com API, default = "https://api.deadmanssnitch.com/v1"
+  --checkin-url [url]                Base URL check ins are sent to, default = "https://nosnch.in"
+  --config [config file]             Configuration file layered over any found, default = ./config.yaml
+  --configured                       Show only the snitches in the snitches list of the config
+  --cert-expiry [duration]           Fail a probe when its certificate expires within this long, "336h"
+  --check-every [duration]           How often heartbeat checks its health conditions, default = 30s
   --create                           Create snitch, requires --name and --interval, optional --tags & --notes
+  --dir [directory]                  Directory of systemd units to audit, default = /etc/systemd/system
   --displayconfig                    Display configuration
+  --every [duration]                 How often to check in, "10m"
+  --expect-answer [regex]            Regex one of the dns probe answers must match
+  --expect-body [regex]              Regex the http probe response body must match
+  --expect-status [code]             Status code the http probe must return, default = any 2xx
+  --fail-match [regex]               Regex for log lines which send an errored check in
+  --file [file]                      Log file to follow
+  --force                            Check in even when --min-gap would suppress it
+  --format [format]                  Snippet format: "curl", "wget", "cron", "k8s-cronjob", "dockerfile-healthcheck" or "github-actions", default = curl
+  --health-cmd [command]             Command which must exit 0 for heartbeat to keep checking in
+  --health-url [url]                 URL which must return 2xx for heartbeat to keep checking in
   --help                             Display help
+  --in-place                         Rewrite the imported crontab in place, keeping a backup
   --interval [interval window]       "15_minute", "30_minute", "hourly", "daily", "weekly", or "monthly"
-  --message [messgage to send]       Message to send, default = "2006-01-02T15:04:05Z07:00" format
+  --listen [host:port]               Address to listen on, default = receive.listen or relay.listen from config
+  --lease-dir [directory]            Shared directory for leases so only one host in a fleet runs a job
+  --lease-ttl [duration]             How long a lease lasts without renewal, longer than the job runs and shorter than its schedule, default = 10m
+  --lock [file]                      Lock file so only one copy of a job runs at once
+  --lock-wait [duration]             How long to wait for the lock before skipping the run, default = 0
+  --log-dir [directory]              Directory job output is logged to, default = [state-dir]/logs
+  --log-keep [count]                 Number of output logs to keep for each job, default = 10
+  --match [regex]                    Regex for log lines which send a check in
+  --max-age [duration]               Maximum age of a watched file, "26h"
+  --message [message to send]        Message to send, a template which can use {{.Hostname}}, {{.ExitCode}} and more,
+                                     default = "2006-01-02T15:04:05Z07:00" format
+  --message-file [file]              File to read the message template from, "-" for stdin
+  --max-duration [duration]          Send an errored check in when a job succeeds but runs for longer than this
+  --min-duration [duration]          Send an errored check in when a job succeeds but runs for less than this
+  --min-gap [duration]               Suppress successful check ins for a snitch within this long of the last one, "10m"
+  --min-size [size]                  Minimum size of a watched file, "1MB"
   --name [name]                      Name of snitch
   --notes [notes]                    Notes for snitch
-  --path [path to config file]       Path to configuration file, default = current directory
+  --only-if-due                      Only run the job when its snitch has no successful check in for the current period
+  --on-success                       Check in from a unit started by OnSuccess= and OnFailure= instead of ExecStopPost=, needs systemd 249
+  --once                             Check once and exit instead of watching
+  --origin                           Show where each setting came from with --displayconfig
+  --output [file]                    File or directory to write output to
+  --output-lines [count]             Number of lines of output to add to the check in when a job fails, default = 5
+  --path [pattern]                   Files to watch, "/backups/*.tar.gz"
   --pause [snitch]                   Pauses a snitch
+  --pid [pid]                        PID which must be alive for heartbeat to keep checking in
+  --pidfile [file]                   PID file naming a process which must be alive for heartbeat to keep checking in
   --plan [plan type]                 Plan type: "free", "small", "medium" or "large", default = free
+  --profile [profile]                Profile from the config to use, default = profile from config.yaml
+  --query [name]                     Name to look up with the dns probe
+  --record-type [type]               Record type for the dns probe: "A", "AAAA", "CNAME", "MX", "NS" or "TXT", default = A
+  --resolver [host:port]             DNS server for the dns probe, default = system resolver
+  --save-as [alias]                  Alias to save the token of a created snitch as in the config file
+  --settle [duration]                How long a watched file must be unchanged before it is checked, default = 5s
   --show                             Display all snitches
   --show --snitch [snitch]           Show details for a specific snitch
+  --show-secrets                     Show secret settings with config get
+  --since [time]                     Show audit log entries since this long ago, date or time, "24h" or "2006-01-02"
   --silent                           Be silent
   --snitch [snitch]                  Snitch to use, default = defaultsnitch from config.yaml
+  --splay [duration]                 Random delay of up to this long added to each heartbeat, "30s"
+  --state-dir [directory]            Directory for local state, default = /var/lib/snitchit for root, ~/.snitchit for others
+  --system                           Crontab has a user field, default = true for /etc/crontab and /etc/cron.d
   --tags [tags]                      Tags separated by commas, "tag1,tag2,tag3"
+  --templates [directory]            Directory of snippet templates, [format].tmpl overrides the built in template
+  --timeout [duration]               Timeout for health checks and probes (default = 10s) and jobs (default = none)
+  --tls                              Perform a TLS handshake in the tcp probe
+  --transport [transports]           Check in transports to try in order, "https,smtp", default = transports from config or "https"
+  --unit [unit]                      Systemd unit, "backup.service"
   --unpause [snitch]                 Unpause a snitch
+  --until [time]                     Show audit log entries until this long ago, date or time, "1h" or "2006-01-02T15:04:05Z"
   --update [snitch]                  Update a snitch, can be used with --name, --interval, --tags & --notes
   --verbose                          Be verbose
+  --url [url]                        URL for the http probe
   --version                          Version
+  --window [duration]                Send at most one check in per window for matching log lines, default = 1m
 `
 	fmt.Printf("%s", helpmessage)
 }
-
-//======================================
-
-func actionSnitch2(todo string, token string, jsonpayload string) bool {
-	token = url.QueryEscape(token)
-	url := "https://api.deadmanssnitch.com/v1/snitches"
-
-	var httpaction string
-	var header string
-
-	switch strings.ToLower(todo) {
-	case "create":
-		httpaction = "POST"
-		header = "application/json"
-	case "read":
-		httpaction = "POST"
-	case "update":
-		httpaction = "PATCH"
-		header = "application/json"
-		url = url + "/" + token
-	case "delete":
-		httpaction = "DELETE"
-		url = url + "/" + token
-	case "pause":
-		httpaction = "POST"
-		url = url + "/" + token + "/pause"
-	}
-
-	bytesaction := []byte(jsonpayload)
-	req, err := http.NewRequest(httpaction, url, bytes.NewBuffer(bytesaction))
-
-	if verbose {
-		fmt.Println("HEADER:", header)
-		fmt.Println("   URL:", url)
-		fmt.Println("  TODO:", todo)
-		fmt.Println("  JSON:", jsonpayload)
-		fmt.Println("Action:", httpaction)
-		fmt.Println(" BYTES:", bytesaction)
-		fmt.Printf("    CMD: req, err := http.NewRequest(%s, %s, %s)\n", httpaction, url, bytes.NewBuffer(bytesaction))
-	}
-
-	req.SetBasicAuth(apikey, "")
-	if err != nil {
-		log.Fatal("NewRequest: ", err)
-		return false
-	}
-
-	if len(header) != 0 {
-		req.Header.Add("Content-Type", header)
-	}
-
-	client := &http.Client{}
-	client.Timeout = time.Second * 15
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-		return false
-	}
-
-	htmlData, _ := ioutil.ReadAll(resp.Body)
-
-	if verbose {
-		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-			fmt.Println("Success")
-		}
-	}
-
-	//if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
-	var errorresponse dmsResp
-	json.Unmarshal(htmlData, &errorresponse)
-	if verbose {
-		fmt.Printf("ERROR: %s/%s  MESSAGE:\"%s\"\n", http.StatusText(resp.StatusCode), errorresponse.Type, errorresponse.Error)
-	}
-	//return false
-	//}
-
-	defer resp.Body.Close()
-
-	return true
-}
@@ -0,0 +1,31 @@
+package main
+
+// state.go
+
+import (
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+)
+
+// stateDir returns a directory under --state-dir for snitchit to keep local state in, creating it if needed.
+// The default is /var/lib/snitchit for root and ~/.snitchit for everyone else.
+func stateDir(sub string) (string, error) {
+	base := viper.GetString("state-dir")
+	if base == "" {
+		base = "/var/lib/snitchit"
+		if os.Geteuid() != 0 {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			base = filepath.Join(home, ".snitchit")
+		}
+	}
+
+	dir := filepath.Join(base, sub)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
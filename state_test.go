@@ -0,0 +1,24 @@
+package main
+
+// state_test.go
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestMain keeps the local state and job logs of every test in a temporary directory
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "snitchit-state")
+	if err != nil {
+		fmt.Println("ERROR: Cannot create state directory:", err)
+		os.Exit(1)
+	}
+	viper.Set("state-dir", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,191 @@
+package main
+
+// stats.go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// historyKeep is how many runs are kept in each job history file
+const historyKeep = 1000
+
+// jobRun is one line of a job history file
+type jobRun struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exit_code"`
+	UserCPU  time.Duration `json:"user_cpu"`
+	SysCPU   time.Duration `json:"sys_cpu"`
+	MaxRSS   int64         `json:"max_rss_kb"`
+}
+
+func historyFile(name string) (string, error) {
+	dir, err := stateDir("history")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, safeFileName(name)+".jsonl"), nil
+}
+
+// recordJob appends the run to the job history, trimming it to the last historyKeep runs
+func recordJob(result jobResult) {
+	path, err := historyFile(result.Name)
+	if err != nil {
+		if verbose {
+			fmt.Println("Job: not recording history:", err)
+		}
+		return
+	}
+
+	line, _ := json.Marshal(jobRun{
+		Started:  result.Started,
+		Duration: result.Duration,
+		ExitCode: result.ExitCode,
+		UserCPU:  result.UserCPU,
+		SysCPU:   result.SysCPU,
+		MaxRSS:   result.MaxRSS,
+	})
+
+	// jobs can finish at the same time, so hold a lock while the history is read and rewritten
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		log.Println("ERROR: Cannot record history for job", result.Name, ":", err)
+		return
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		log.Println("ERROR: Cannot lock history for job", result.Name, ":", err)
+		return
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	data, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] == "" {
+		lines = nil
+	}
+	lines = append(lines, string(line))
+	if len(lines) > historyKeep {
+		lines = lines[len(lines)-historyKeep:]
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".history-")
+	if err == nil {
+		_, err = temp.WriteString(strings.Join(lines, "\n") + "\n")
+		temp.Close()
+		if err == nil {
+			err = os.Rename(temp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(temp.Name())
+		}
+	}
+	if err != nil {
+		log.Println("ERROR: Cannot record history for job", result.Name, ":", err)
+	}
+}
+
+func loadHistory(name string) ([]jobRun, error) {
+	path, err := historyFile(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var runs []jobRun
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var run jobRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err == nil {
+			runs = append(runs, run)
+		}
+	}
+	return runs, scanner.Err()
+}
+
+// jobStats summarises the run times of a job from its history
+func jobStats() {
+	name := pflag.Arg(1)
+	if name == "" {
+		fmt.Println("ERROR: No job given, use \"snitchit stats <job>\"")
+		os.Exit(1)
+	}
+
+	runs, err := loadHistory(name)
+	if err != nil || len(runs) == 0 {
+		fmt.Println("ERROR: No history for job", name)
+		os.Exit(1)
+	}
+
+	var durations, cpu []time.Duration
+	var failed int
+	var maxrss int64
+	for _, run := range runs {
+		durations = append(durations, run.Duration)
+		cpu = append(cpu, run.UserCPU+run.SysCPU)
+		if run.ExitCode != 0 {
+			failed++
+		}
+		if run.MaxRSS > maxrss {
+			maxrss = run.MaxRSS
+		}
+	}
+
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "Runs", "Failed", "Min", "p50", "p90", "p95", "p99", "Max", "CPU p50", "Max RSS")
+	fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", len(runs), failed,
+		formatDuration(percentile(durations, 0)),
+		formatDuration(percentile(durations, 50)),
+		formatDuration(percentile(durations, 90)),
+		formatDuration(percentile(durations, 95)),
+		formatDuration(percentile(durations, 99)),
+		formatDuration(percentile(durations, 100)),
+		formatDuration(percentile(cpu, 50)),
+		formatSize(maxrss*1024))
+	w.Flush()
+
+	if !silent {
+		last := runs[len(runs)-1]
+		fmt.Printf("\nLast run %s, exited %d after %s\n", last.Started.Format(time.RFC3339), last.ExitCode, formatDuration(last.Duration))
+	}
+}
+
+// percentile returns the nearest rank percentile p of durations, p = 0 being the minimum
+func percentile(durations []time.Duration, p int) time.Duration {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+// stats_test.go
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var hundred []time.Duration
+	for i := 100; i >= 1; i-- {
+		hundred = append(hundred, time.Duration(i)*time.Second)
+	}
+	unsorted := []time.Duration{5 * time.Second, time.Second, 3 * time.Second, 2 * time.Second, 4 * time.Second}
+
+	tests := []struct {
+		durations []time.Duration
+		p         int
+		want      time.Duration
+	}{
+		{[]time.Duration{time.Minute}, 0, time.Minute},
+		{[]time.Duration{time.Minute}, 99, time.Minute},
+		{unsorted, 0, time.Second},
+		{unsorted, 20, time.Second},
+		{unsorted, 21, 2 * time.Second},
+		{unsorted, 50, 3 * time.Second},
+		{unsorted, 90, 5 * time.Second},
+		{unsorted, 100, 5 * time.Second},
+		{hundred, 0, time.Second},
+		{hundred, 50, 50 * time.Second},
+		{hundred, 90, 90 * time.Second},
+		{hundred, 99, 99 * time.Second},
+		{hundred, 100, 100 * time.Second},
+	}
+	for _, test := range tests {
+		if got := percentile(test.durations, test.p); got != test.want {
+			t.Errorf("percentile(%v, %d) = %s, want %s", test.durations, test.p, got, test.want)
+		}
+	}
+
+	// the durations given are left in their order
+	if unsorted[0] != 5*time.Second {
+		t.Errorf("percentile sorted the durations it was given, %v", unsorted)
+	}
+}
+
+func TestRunJobRecordsHistory(t *testing.T) {
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+
+	tests := []struct {
+		job     job
+		checkin string
+		problem string
+	}{
+		{job{Command: "true"}, "abc [0] ", ""},
+		{job{Command: "sleep 0.1", MaxDuration: 10 * time.Millisecond}, "abc [1] ", "longer than max duration 10ms"},
+		{job{Command: "true", MinDuration: time.Hour}, "abc [1] ", "shorter than min duration 1h0m0s"},
+		{job{Command: "exit 4", MinDuration: time.Hour}, "abc [4] ", ""},
+	}
+	for i, test := range tests {
+		test.job.Name = "history"
+		test.job.Snitch = "abc"
+		result := runJob(test.job)
+		if result.Problem != test.problem {
+			t.Errorf("runJob(%q) problem = %q, want %q", test.job.Command, result.Problem, test.problem)
+		}
+		checkin := received()[i]
+		if !strings.HasPrefix(checkin, test.checkin) || !strings.Contains(checkin, test.problem) {
+			t.Errorf("runJob(%q) checked in %q, want %q...%q", test.job.Command, checkin, test.checkin, test.problem)
+		}
+	}
+
+	runs, err := loadHistory("history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != len(tests) {
+		t.Fatalf("history has %d runs, want %d", len(runs), len(tests))
+	}
+	if runs[1].Duration < 100*time.Millisecond || runs[3].ExitCode != 4 || runs[0].MaxRSS <= 0 {
+		t.Errorf("history = %+v", runs)
+	}
+}
+
+func TestRecordJobConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recordJob(jobResult{Name: "concurrent", ExitCode: i, Started: time.Now()})
+		}(i)
+	}
+	wg.Wait()
+
+	// every run survives when the history is rewritten by several jobs at once
+	runs, err := loadHistory("concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	for _, run := range runs {
+		seen[run.ExitCode] = true
+	}
+	if len(runs) != 20 || len(seen) != 20 {
+		t.Errorf("history has %d runs with %d exit codes, want 20", len(runs), len(seen))
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+// systemd.go
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// unitFile holds the values of a unit file and its drop-ins, keyed by "Section.Key"
+type unitFile map[string][]string
+
+var calendarShortcuts = map[string]string{
+	"minutely":     "*-*-* *:*:00",
+	"hourly":       "*-*-* *:00:00",
+	"daily":        "*-*-* 00:00:00",
+	"weekly":       "Mon *-*-* 00:00:00",
+	"monthly":      "*-*-01 00:00:00",
+	"quarterly":    "*-01,04,07,10-01 00:00:00",
+	"semiannually": "*-01,07-01 00:00:00",
+	"yearly":       "*-01-01 00:00:00",
+	"annually":     "*-01-01 00:00:00",
+}
+
+var snitchArgument = regexp.MustCompile(`snitchit.*--snitch[ =]['"]?([^'"\s]+)`)
+
+func systemdCommand() {
+	switch strings.ToLower(pflag.Arg(1)) {
+	case "generate":
+		generateUnits()
+	case "audit":
+		auditTimers()
+	case "notify":
+		notifyFromSystemd()
+	default:
+		fmt.Println("ERROR: Invalid systemd command", pflag.Arg(1), ". Please choose either \"generate\", \"audit\" or \"notify\"")
+		os.Exit(1)
+	}
+}
+
+// generateUnits writes a drop-in for --unit which checks in whenever the service stops
+func generateUnits() {
+	unit := viper.GetString("unit")
+	if unit == "" {
+		fmt.Println("ERROR: --unit cannot be blank")
+		os.Exit(1)
+	}
+	if !strings.Contains(unit, ".") {
+		unit = unit + ".service"
+	}
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "/usr/local/bin/snitchit"
+	}
+	notify := shellQuote(binary)
+	if viper.ConfigFileUsed() != "" {
+		if abs, err := filepath.Abs(viper.ConfigFileUsed()); err == nil {
+			notify = notify + " --config " + shellQuote(abs)
+		}
+	}
+	notify = notify + " --silent systemd notify --snitch " + shellQuote(snitch) + " --unit " + shellQuote(unit)
+	// systemd expands % specifiers in exec lines
+	notify = strings.Replace(notify, "%", "%%", -1)
+
+	files := make(map[string]string)
+	dropin := filepath.Join(unit+".d", "snitchit.conf")
+
+	if viper.GetBool("on-success") {
+		// OnSuccess= needs systemd 249 or later, the triggered unit sees the result as MONITOR_* variables
+		checkin := "snitchit-" + strings.TrimSuffix(unit, filepath.Ext(unit)) + ".service"
+		files[dropin] = "[Unit]\nOnSuccess=" + checkin + "\nOnFailure=" + checkin + "\n"
+		files[checkin] = "[Unit]\nDescription=Check in to deadmanssnitch.com for " + unit + "\n\n[Service]\nType=oneshot\nExecStart=" + notify + "\n"
+	} else {
+		// "-" so a failed check in never marks the service itself as failed
+		files[dropin] = "[Service]\nExecStopPost=-" + notify + "\n"
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output := viper.GetString("output")
+	for _, name := range names {
+		if output == "" {
+			fmt.Printf("# %s\n%s\n", filepath.Join("/etc/systemd/system", name), files[name])
+			continue
+		}
+		path := filepath.Join(output, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fmt.Println("ERROR: Cannot create", filepath.Dir(path), ":", err)
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			fmt.Println("ERROR: Cannot write", path, ":", err)
+			os.Exit(1)
+		}
+		if !silent {
+			fmt.Println("Wrote", path)
+		}
+	}
+
+	if output != "" && !silent {
+		fmt.Println("Run \"systemctl daemon-reload\" to load the changes")
+	}
+}
+
+// notifyFromSystemd checks in with the result systemd passes to ExecStopPost= or OnSuccess=/OnFailure= units
+func notifyFromSystemd() {
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	// ExecStopPost= gets SERVICE_RESULT, units started by OnSuccess= and OnFailure= get MONITOR_SERVICE_RESULT
+	prefix := ""
+	if os.Getenv("MONITOR_SERVICE_RESULT") != "" {
+		prefix = "MONITOR_"
+	}
+	result := os.Getenv(prefix + "SERVICE_RESULT")
+	exitcode := os.Getenv(prefix + "EXIT_CODE")
+	exitstatus := os.Getenv(prefix + "EXIT_STATUS")
+
+	unit := viper.GetString("unit")
+	if unit == "" {
+		unit = os.Getenv("MONITOR_UNIT")
+	}
+
+	if result == "" {
+		fmt.Println("ERROR: No SERVICE_RESULT from systemd, \"snitchit systemd notify\" must be run by ExecStopPost= or OnSuccess=")
+		os.Exit(1)
+	}
+
+	status := ""
+	msg := unit + " succeeded"
+	if result != "success" {
+		// exit statuses are numbers for exited services and signal names for killed ones
+		status = "1"
+		if code, err := strconv.Atoi(exitstatus); err == nil && code != 0 {
+			status = exitstatus
+		}
+		msg = fmt.Sprintf("%s failed: %s", unit, result)
+		if exitcode != "" {
+			msg = fmt.Sprintf("%s, %s %s", msg, exitcode, exitstatus)
+		}
+	}
+
+	code, _ := strconv.Atoi(exitstatus)
+	if custom := customMessage(messageFacts{Snitch: snitch, Job: unit, ExitCode: code, Message: msg}); custom != "" {
+		msg = custom
+	}
+
+	if err := sendCheckIn(snitch, msg, status); err != nil {
+		fmt.Println("ERROR: Check in failed:", err)
+		os.Exit(1)
+	}
+
+	if !silent {
+		fmt.Println("Success")
+	}
+}
+
+// auditTimers lists timers with no snitch, or with a snitch whose interval does not suit the timer
+func auditTimers() {
+	if len(apikey) == 0 {
+		fmt.Println("ERROR: No API Key provided")
+		os.Exit(1)
+	}
+
+	dir := viper.GetString("dir")
+	timers, err := filepath.Glob(filepath.Join(dir, "*.timer"))
+	if err != nil || len(timers) == 0 {
+		fmt.Println("ERROR: No timers found in", dir)
+		os.Exit(1)
+	}
+	sort.Strings(timers)
+
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(os.Stdout, 10, 8, 4, '\t', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", "Timer", "Schedule", "Needs", "Snitch", "Interval", "Status")
+
+	problems := 0
+	for _, path := range timers {
+		timer := filepath.Base(path)
+		timerunit, err := readUnitFile(dir, timer)
+		if err != nil {
+			fmt.Println("ERROR: Cannot read", timer, ":", err)
+			os.Exit(1)
+		}
+
+		service := strings.TrimSuffix(timer, ".timer") + ".service"
+		if values := timerunit["Timer.Unit"]; len(values) != 0 {
+			service = values[len(values)-1]
+		}
+
+		schedule := strings.Join(append(timerunit["Timer.OnCalendar"], timerunit["Timer.OnUnitActiveSec"]...), ", ")
+
+		needs := ""
+		gap, err := timerGap(timerunit)
+		if err != nil {
+			needs = "unknown"
+		} else if interval, ok := nearestInterval(gap); ok {
+			needs = interval
+		} else {
+			needs = "none"
+		}
+
+		token := unitSnitch(dir, service)
+
+		status := "OK"
+		interval := ""
+		switch {
+		case token == "":
+			status = "NO SNITCH"
+		case err != nil:
+			status = "UNKNOWN SCHEDULE: " + err.Error()
+		default:
+			found, err := getSnitch(token)
+			if err != nil {
+				status = "ERROR: " + err.Error()
+				break
+			}
+			interval = found.Interval
+			switch {
+			case needs == "none":
+				status = "MISMATCH: no interval fits the schedule"
+			case intervalPeriod(interval) < gap:
+				status = "MISMATCH: interval shorter than the schedule, the snitch will alert between runs"
+			case interval != needs:
+				status = "MISMATCH: interval longer than needed, missed runs alert late"
+			}
+		}
+		if status != "OK" {
+			problems++
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", timer, schedule, needs, token, interval, status)
+	}
+	w.Flush()
+
+	if problems != 0 {
+		os.Exit(1)
+	}
+}
+
+// readUnitFile reads a unit from dir along with any drop-ins in dir/unit.d
+func readUnitFile(dir string, name string) (unitFile, error) {
+	paths := []string{filepath.Join(dir, name)}
+	dropins, _ := filepath.Glob(filepath.Join(dir, name+".d", "*.conf"))
+	sort.Strings(dropins)
+	paths = append(paths, dropins...)
+
+	unit := make(unitFile)
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			if i != 0 {
+				continue
+			}
+			return nil, err
+		}
+
+		section := ""
+		continued := ""
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if continued != "" {
+				line = continued + " " + line
+				continued = ""
+			}
+			if strings.HasSuffix(line, `\`) {
+				continued = strings.TrimSuffix(line, `\`)
+				continue
+			}
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+				continue
+			}
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				section = strings.Trim(line, "[]")
+				continue
+			}
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			key := section + "." + strings.TrimSpace(parts[0])
+			value := strings.TrimSpace(parts[1])
+			if value == "" {
+				// an empty assignment resets the list
+				delete(unit, key)
+				continue
+			}
+			unit[key] = append(unit[key], value)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return unit, nil
+}
+
+// unitSnitch finds the snitch a service checks in to, from its exec lines or the units it starts on success
+func unitSnitch(dir string, service string) string {
+	unit, err := readUnitFile(dir, service)
+	if err != nil {
+		return ""
+	}
+
+	for _, key := range []string{"Service.ExecStart", "Service.ExecStartPost", "Service.ExecStopPost"} {
+		for _, value := range unit[key] {
+			if match := snitchArgument.FindStringSubmatch(value); match != nil {
+				return match[1]
+			}
+		}
+	}
+
+	for _, key := range []string{"Unit.OnSuccess", "Unit.OnFailure"} {
+		for _, value := range unit[key] {
+			for _, other := range strings.Fields(value) {
+				if other == service {
+					continue
+				}
+				if token := unitSnitch(dir, other); token != "" {
+					return token
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// timerGap returns the longest time between two runs of a timer, when it has several triggers the most frequent one bounds it
+func timerGap(timer unitFile) (time.Duration, error) {
+	var gap time.Duration
+	shortest := func(d time.Duration) {
+		if gap == 0 || d < gap {
+			gap = d
+		}
+	}
+
+	for _, key := range []string{"Timer.OnUnitActiveSec", "Timer.OnUnitInactiveSec"} {
+		for _, value := range timer[key] {
+			span, err := parseTimespan(value)
+			if err != nil {
+				return 0, err
+			}
+			shortest(span)
+		}
+	}
+
+	var schedules []*cronSchedule
+	for _, value := range timer["Timer.OnCalendar"] {
+		schedule, err := parseCalendar(value)
+		if err != nil {
+			return 0, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if len(schedules) != 0 {
+		// a timer with several OnCalendar= lines runs at the union of them
+		next := func(t time.Time) time.Time {
+			var soonest time.Time
+			for _, schedule := range schedules {
+				if n := schedule.next(t); !n.IsZero() && (soonest.IsZero() || n.Before(soonest)) {
+					soonest = n
+				}
+			}
+			return soonest
+		}
+		if calendargap := scheduleGap(next, time.Now().UTC()); calendargap > 0 {
+			shortest(calendargap)
+		}
+	}
+
+	if gap == 0 {
+		return 0, fmt.Errorf("no OnCalendar= or OnUnitActiveSec=")
+	}
+	return gap, nil
+}
+
+// parseCalendar converts a systemd OnCalendar= expression such as "Mon..Fri *-*-* 02:30" to a cron schedule, ignoring seconds
+func parseCalendar(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := calendarShortcuts[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	dow, dom, month, minute, hour := "*", "*", "*", "0", "0"
+	timeset := false
+
+	for _, part := range strings.Fields(spec) {
+		switch {
+		case strings.Contains(part, "~"):
+			return nil, fmt.Errorf("unsupported calendar %q", spec)
+		case strings.Contains(part, ":"):
+			fields := strings.Split(part, ":")
+			if len(fields) < 2 || len(fields) > 3 {
+				return nil, fmt.Errorf("invalid time %q", part)
+			}
+			hour, minute = fields[0], fields[1]
+			timeset = true
+		case strings.Contains(part, "-") && strings.IndexAny(part[:1], "0123456789*") == 0:
+			fields := strings.Split(part, "-")
+			switch len(fields) {
+			case 2:
+				month, dom = fields[0], fields[1]
+			case 3:
+				month, dom = fields[1], fields[2]
+			default:
+				return nil, fmt.Errorf("invalid date %q", part)
+			}
+		case strings.IndexAny(part[:1], "0123456789*") != 0 && (timeset || dom != "*" || month != "*"):
+			// anything after the date and time is a timezone, such as UTC or Europe/London
+			continue
+		case strings.IndexAny(part[:1], "0123456789*") != 0 && !strings.Contains(part, "/"):
+			dow = strings.ToLower(part)
+		default:
+			return nil, fmt.Errorf("invalid calendar %q", spec)
+		}
+	}
+
+	fields := []string{minute, hour, dom, month, dow}
+	for i := range fields {
+		fields[i] = strings.Replace(fields[i], "..", "-", -1)
+		// cron only understands three letter day names
+		for _, day := range cronDays {
+			fields[i] = regexp.MustCompile(`\b`+day+`[a-z]*\b`).ReplaceAllString(fields[i], day)
+		}
+	}
+
+	schedule, err := parseCron(strings.Join(fields, " "))
+	if err != nil {
+		return nil, fmt.Errorf("calendar %q: %s", spec, err)
+	}
+	return schedule, nil
+}
+
+// parseTimespan parses systemd time spans such as "15min", "1h 30m" or "90"
+func parseTimespan(span string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"": time.Second, "us": time.Microsecond, "ms": time.Millisecond,
+		"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+		"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+		"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+		"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+		"M": 30 * 24 * time.Hour, "month": 30 * 24 * time.Hour, "months": 30 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour, "year": 365 * 24 * time.Hour, "years": 365 * 24 * time.Hour,
+	}
+
+	matches := regexp.MustCompile(`(\d+)\s*([a-zA-Z]*)`).FindAllStringSubmatch(span, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("invalid time span %q", span)
+	}
+
+	var total time.Duration
+	for _, match := range matches {
+		number, _ := strconv.Atoi(match[1])
+		unit, ok := units[match[2]]
+		if !ok {
+			return 0, fmt.Errorf("invalid time span %q", span)
+		}
+		total += time.Duration(number) * unit
+	}
+	return total, nil
+}
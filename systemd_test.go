@@ -0,0 +1,189 @@
+package main
+
+// systemd_test.go
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCalendar(t *testing.T) {
+	tests := []struct {
+		calendar string
+		cron     string // the equivalent cron schedule, "" when the calendar is not supported
+	}{
+		{"daily", "0 0 * * *"},
+		{"Hourly", "0 * * * *"},
+		{"minutely", "* * * * *"},
+		{"weekly", "0 0 * * mon"},
+		{"monthly", "0 0 1 * *"},
+		{"quarterly", "0 0 1 1,4,7,10 *"},
+		{"yearly", "0 0 1 1 *"},
+		{"*-*-* 02:30:00", "30 2 * * *"},
+		{"*-*-* 02:30", "30 2 * * *"},
+		{"Mon..Fri *-*-* 02:30", "30 2 * * mon-fri"},
+		{"Monday,Wednesday 09:00", "0 9 * * mon,wed"},
+		{"Sat 04:15", "15 4 * * sat"},
+		{"2026-*-15 12:00", "0 12 15 * *"},
+		{"*-06-01", "0 0 1 6 *"},
+		{"*-*-* *:0/15", "0/15 * * * *"},
+		{"*-*-* 03:00 UTC", "0 3 * * *"},
+		{"*-*-* 02:30 Europe/London", "30 2 * * *"},
+		{"*-*-* 02:30:00~1h", ""},
+		{"*-*-* 2:3:4:5", ""},
+		{"1-2-3-4", ""},
+		{"*-*-* 25:00", ""},
+	}
+	for _, test := range tests {
+		got, err := parseCalendar(test.calendar)
+		if test.cron == "" {
+			if err == nil {
+				t.Errorf("parseCalendar(%q) succeeded, want an error", test.calendar)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCalendar(%q) = %v", test.calendar, err)
+			continue
+		}
+		want, err := parseCron(test.cron)
+		if err != nil {
+			t.Fatalf("parseCron(%q) = %v", test.cron, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseCalendar(%q) = %+v, want %q %+v", test.calendar, got, test.cron, want)
+		}
+	}
+}
+
+func TestParseTimespan(t *testing.T) {
+	tests := []struct {
+		span  string
+		want  time.Duration
+		fails bool
+	}{
+		{"90", 90 * time.Second, false},
+		{"15min", 15 * time.Minute, false},
+		{"1h 30m", 90 * time.Minute, false},
+		{"1h30min", 90 * time.Minute, false},
+		{"2 days", 48 * time.Hour, false},
+		{"1w", 7 * 24 * time.Hour, false},
+		{"1M", 30 * 24 * time.Hour, false},
+		{"1y", 365 * 24 * time.Hour, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"", 0, true},
+		{"soon", 0, true},
+		{"5 fortnights", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseTimespan(test.span)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseTimespan(%q) = %s, want an error", test.span, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseTimespan(%q) = %s, %v, want %s", test.span, got, err, test.want)
+		}
+	}
+}
+
+func TestGenerateUnits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string, q bool) { snitch, silent = s, q }(snitch, silent)
+	snitch, silent = "abc", true
+	defer viper.Set("unit", nil)
+	defer viper.Set("output", nil)
+	defer viper.Set("on-success", nil)
+
+	binary, _ := os.Executable()
+	notify := shellQuote(binary)
+	// a config loaded by an earlier test is passed on as well
+	if viper.ConfigFileUsed() != "" {
+		config, _ := filepath.Abs(viper.ConfigFileUsed())
+		notify += " --config " + shellQuote(config)
+	}
+	notify = strings.Replace(notify, "%", "%%", -1) + " --silent systemd notify --snitch 'abc' --unit 'backup.service'"
+
+	tests := []struct {
+		onsuccess bool
+		files     map[string]string
+	}{
+		{false, map[string]string{
+			"backup.service.d/snitchit.conf": "[Service]\nExecStopPost=-" + notify + "\n",
+		}},
+		{true, map[string]string{
+			"backup.service.d/snitchit.conf": "[Unit]\nOnSuccess=snitchit-backup.service\nOnFailure=snitchit-backup.service\n",
+			"snitchit-backup.service":        "[Unit]\nDescription=Check in to deadmanssnitch.com for backup.service\n\n[Service]\nType=oneshot\nExecStart=" + notify + "\n",
+		}},
+	}
+	for _, test := range tests {
+		output := filepath.Join(dir, "onsuccess-"+strconv.FormatBool(test.onsuccess))
+		viper.Set("unit", "backup")
+		viper.Set("output", output)
+		viper.Set("on-success", test.onsuccess)
+		generateUnits()
+
+		for name, want := range test.files {
+			got, err := ioutil.ReadFile(filepath.Join(output, name))
+			if err != nil || string(got) != want {
+				t.Errorf("on-success %v wrote %s = %q, %v, want %q", test.onsuccess, name, got, err, want)
+			}
+		}
+		written, _ := filepath.Glob(filepath.Join(output, "*"))
+		if len(written) != len(test.files) {
+			t.Errorf("on-success %v wrote %q, want %d files", test.onsuccess, written, len(test.files))
+		}
+	}
+}
+
+func TestUnitSnitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	units := map[string]string{
+		"plain.service":                  "[Service]\nExecStart=/usr/local/bin/backup\n",
+		"wrapped.service":                "[Service]\nExecStart=/usr/local/bin/snitchit run --snitch abc -- /usr/local/bin/backup\n",
+		"dropin.service":                 "[Service]\nExecStart=/usr/local/bin/backup\n",
+		"dropin.service.d/snitchit.conf": "[Service]\nExecStopPost=-'/usr/local/bin/snitchit' --silent systemd notify --snitch 'def' \\\n  --unit 'dropin.service'\n",
+		"monitored.service":              "[Unit]\nOnSuccess=notify-monitored.service\n\n[Service]\nExecStart=/usr/local/bin/backup\n",
+		"notify-monitored.service":       "[Service]\nExecStart=snitchit systemd notify --snitch=ghi\n",
+		"looped.service":                 "[Unit]\nOnFailure=looped.service\n",
+	}
+	for name, content := range units {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{"plain.service", ""},
+		{"wrapped.service", "abc"},
+		{"dropin.service", "def"},
+		{"monitored.service", "ghi"},
+		{"looped.service", ""},
+		{"missing.service", ""},
+	}
+	for _, test := range tests {
+		if got := unitSnitch(dir, test.service); got != test.want {
+			t.Errorf("unitSnitch(%q) = %q, want %q", test.service, got, test.want)
+		}
+	}
+}
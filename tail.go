@@ -0,0 +1,254 @@
+package main
+
+// tail.go
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// follower reads lines appended to a file, following it across rotation and truncation
+type follower struct {
+	path    string
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+func tailFile() {
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	path := viper.GetString("file")
+	if path == "" {
+		fmt.Println("ERROR: --file cannot be blank")
+		os.Exit(1)
+	}
+
+	if viper.GetString("match") == "" && viper.GetString("fail-match") == "" {
+		fmt.Println("ERROR: --match and/or --fail-match must be set")
+		os.Exit(1)
+	}
+
+	var match, failmatch *regexp.Regexp
+	var err error
+	if viper.GetString("match") != "" {
+		if match, err = regexp.Compile(viper.GetString("match")); err != nil {
+			fmt.Println("ERROR: Invalid --match regex:", err)
+			os.Exit(1)
+		}
+	}
+	if viper.GetString("fail-match") != "" {
+		if failmatch, err = regexp.Compile(viper.GetString("fail-match")); err != nil {
+			fmt.Println("ERROR: Invalid --fail-match regex:", err)
+			os.Exit(1)
+		}
+	}
+
+	window := viper.GetDuration("window")
+
+	f := &follower{path: path}
+	if err := f.open(true); err != nil {
+		fmt.Println("ERROR: Cannot open", path, ":", err)
+		os.Exit(1)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Println("ERROR: Cannot create file watcher:", err)
+		os.Exit(1)
+	}
+	defer watcher.Close()
+
+	// watch the directory rather than the file so rotation is seen
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		fmt.Println("ERROR: Cannot watch", filepath.Dir(path), ":", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	// some filesystems do not deliver events, so poll as well
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+
+	var lastmatch, lastfail time.Time
+
+	handle := func(line string) {
+		if failmatch != nil && failmatch.MatchString(line) {
+			if window > 0 && time.Since(lastfail) < window {
+				if verbose {
+					fmt.Println("Tail: suppressed duplicate failure:", line)
+				}
+				return
+			}
+			if err := sendCheckIn(snitch, line, "1"); err != nil {
+				log.Println("ERROR: Check in failed:", err)
+				return
+			}
+			lastfail = time.Now()
+			if !silent {
+				fmt.Println("Tail: sent failure:", line)
+			}
+			return
+		}
+
+		if match != nil && match.MatchString(line) {
+			if window > 0 && time.Since(lastmatch) < window {
+				if verbose {
+					fmt.Println("Tail: suppressed duplicate match:", line)
+				}
+				return
+			}
+			msg := customMessage(messageFacts{Snitch: snitch, Message: line})
+			if msg == "" {
+				msg = line
+			}
+			if err := sendCheckIn(snitch, msg, ""); err != nil {
+				log.Println("ERROR: Check in failed:", err)
+				return
+			}
+			lastmatch = time.Now()
+			if !silent {
+				fmt.Println("Tail: checked in:", line)
+			}
+		}
+	}
+
+	if !silent {
+		fmt.Printf("Following %s for %s\n", path, snitch)
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if !silent {
+				fmt.Println("Tail stopping on", sig)
+			}
+			return
+
+		case err := <-watcher.Errors:
+			log.Println("ERROR: File watcher:", err)
+
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) != filepath.Clean(path) {
+				continue
+			}
+			if verbose {
+				fmt.Println("Tail:", event)
+			}
+			f.poll(handle)
+
+		case <-poll.C:
+			f.poll(handle)
+		}
+	}
+}
+
+// open opens the followed file, starting at the end when skip is set
+func (f *follower) open(skip bool) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.info = info
+	f.offset = 0
+	f.partial = nil
+
+	if skip {
+		f.offset, err = file.Seek(0, io.SeekEnd)
+	}
+	return err
+}
+
+// poll reads any new lines, then reopens the file if it was rotated or truncated
+func (f *follower) poll(handle func(string)) {
+	if f.file != nil {
+		f.read(handle)
+	}
+
+	current, err := os.Stat(f.path)
+	if err != nil {
+		// rotated away and not yet recreated
+		return
+	}
+
+	if f.file == nil || !os.SameFile(current, f.info) {
+		if f.file != nil {
+			f.read(handle)
+			f.flush(handle)
+			f.file.Close()
+			f.file = nil
+		}
+		if err := f.open(false); err != nil {
+			return
+		}
+		if verbose {
+			fmt.Println("Tail: reopened rotated file", f.path)
+		}
+		f.read(handle)
+		return
+	}
+
+	if current.Size() < f.offset {
+		if verbose {
+			fmt.Println("Tail: file truncated", f.path)
+		}
+		f.file.Seek(0, io.SeekStart)
+		f.offset = 0
+		f.partial = nil
+		f.read(handle)
+	}
+}
+
+func (f *follower) read(handle func(string)) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.file.Read(buf)
+		if n > 0 {
+			f.offset += int64(n)
+			f.partial = append(f.partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(f.partial, '\n')
+				if i < 0 {
+					break
+				}
+				handle(strings.TrimRight(string(f.partial[:i]), "\r"))
+				f.partial = f.partial[i+1:]
+			}
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// flush hands over a final line which was not newline terminated
+func (f *follower) flush(handle func(string)) {
+	if len(f.partial) != 0 {
+		handle(strings.TrimRight(string(f.partial), "\r"))
+		f.partial = nil
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+// throttle.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var throttlemu sync.Mutex
+
+// throttled reports whether a successful check in should be suppressed because the last one was under --min-gap ago
+func throttled(token string, status string) bool {
+	mingap := viper.GetDuration("min-gap")
+	if mingap <= 0 || viper.GetBool("force") || (status != "" && status != "0") {
+		return false
+	}
+
+	last, ok := lastCheckIns()[token]
+	if !ok || time.Since(last) >= mingap {
+		return false
+	}
+
+	if verbose {
+		fmt.Printf("Throttle: suppressed check in for %s, last one was %s ago, --min-gap is %s\n", token, time.Since(last).Round(time.Second), mingap)
+	}
+	return true
+}
+
+// recordCheckIn remembers when the last successful check in for token was sent, when --min-gap or --only-if-due will need it
+func recordCheckIn(token string, status string) {
+	if status != "" && status != "0" {
+		return
+	}
+	if viper.GetDuration("min-gap") <= 0 && !viper.GetBool("only-if-due") {
+		return
+	}
+
+	throttlemu.Lock()
+	defer throttlemu.Unlock()
+
+	path, err := checkInStateFile()
+	if err != nil {
+		return
+	}
+
+	// other snitchit processes check in at the same time, so hold a lock while the state is read and rewritten
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		if verbose {
+			fmt.Println("Throttle: cannot lock state:", err)
+		}
+		return
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		if verbose {
+			fmt.Println("Throttle: cannot lock state:", err)
+		}
+		return
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	checkins := lastCheckIns()
+	checkins[token] = time.Now()
+
+	data, err := json.MarshalIndent(checkins, "", "  ")
+	if err != nil {
+		return
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".checkins-")
+	if err != nil {
+		if verbose {
+			fmt.Println("Throttle: cannot save state:", err)
+		}
+		return
+	}
+	temp.Write(data)
+	temp.Close()
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+	}
+}
+
+func lastCheckIns() map[string]time.Time {
+	checkins := make(map[string]time.Time)
+	path, err := checkInStateFile()
+	if err != nil {
+		return checkins
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return checkins
+	}
+	json.Unmarshal(data, &checkins)
+	return checkins
+}
+
+func checkInStateFile() (string, error) {
+	dir, err := stateDir("")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "checkins.json"), nil
+}
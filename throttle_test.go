@@ -0,0 +1,115 @@
+package main
+
+// throttle_test.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMinGap(t *testing.T) {
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+	defer viper.Set("min-gap", nil)
+	defer viper.Set("force", nil)
+
+	// without --min-gap nothing is throttled or remembered
+	sendCheckIn("gap0", "first", "")
+	sendCheckIn("gap0", "second", "")
+	if _, ok := lastCheckIns()["gap0"]; ok {
+		t.Errorf("check in remembered without --min-gap")
+	}
+
+	viper.Set("min-gap", time.Hour)
+	sendCheckIn("gap1", "first", "")
+	sendCheckIn("gap1", "suppressed", "")
+	sendCheckIn("gap2", "other snitch", "")
+	sendCheckIn("gap1", "failure", "1")
+	sendCheckIn("gap1", "suppressed after failure", "")
+	deliverCheckIn("gap1", "delivered", "")
+
+	viper.Set("force", true)
+	sendCheckIn("gap1", "forced", "")
+	viper.Set("force", false)
+
+	// once the gap has passed check ins go through again
+	checkins := lastCheckIns()
+	checkins["gap2"] = time.Now().Add(-2 * time.Hour)
+	state, err := checkInStateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(checkins)
+	ioutil.WriteFile(state, data, 0600)
+	sendCheckIn("gap2", "after the gap", "")
+
+	want := []string{
+		"gap0 first",
+		"gap0 second",
+		"gap1 first",
+		"gap2 other snitch",
+		"gap1 [1] failure",
+		"gap1 delivered",
+		"gap1 forced",
+		"gap2 after the gap",
+	}
+	if got := received(); !equalStrings(got, want) {
+		t.Errorf("check ins = %q, want %q", got, want)
+	}
+}
+
+// TestRecordCheckInProcess records check ins from a separate process for TestRecordCheckInsConcurrently
+func TestRecordCheckInProcess(t *testing.T) {
+	dir := os.Getenv("SNITCHIT_TEST_STATE_DIR")
+	if dir == "" {
+		t.Skip("only run by TestRecordCheckInsConcurrently")
+	}
+	defer func(previous string) { viper.Set("state-dir", previous) }(viper.GetString("state-dir"))
+	defer viper.Set("min-gap", nil)
+	viper.Set("state-dir", dir)
+	viper.Set("min-gap", time.Hour)
+	for i := 0; i < 20; i++ {
+		recordCheckIn(fmt.Sprintf("%s-%d", os.Getenv("SNITCHIT_TEST_PROCESS"), i), "")
+	}
+}
+
+func TestRecordCheckInsConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// separate snitchit processes check in at once, each rewriting the state
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestRecordCheckInProcess$")
+			cmd.Env = append(os.Environ(), "SNITCHIT_TEST_STATE_DIR="+dir, fmt.Sprintf("SNITCHIT_TEST_PROCESS=p%d", p))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("process %d: %s\n%s", p, err, out)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	defer func(previous string) { viper.Set("state-dir", previous) }(viper.GetString("state-dir"))
+	viper.Set("state-dir", dir)
+	checkins := lastCheckIns()
+	for p := 0; p < 4; p++ {
+		for i := 0; i < 20; i++ {
+			if token := fmt.Sprintf("p%d-%d", p, i); checkins[token].IsZero() {
+				t.Errorf("check in for %s was lost", token)
+			}
+		}
+	}
+}
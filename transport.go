@@ -0,0 +1,197 @@
+package main
+
+// transport.go
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sendCheckIn delivers a check in unless --min-gap suppresses it
+func sendCheckIn(token string, msg string, status string) error {
+	if throttled(token, status) {
+		return nil
+	}
+	return deliverCheckIn(token, msg, status)
+}
+
+// deliverCheckIn sends a check in using each configured transport in turn until one succeeds, whatever --min-gap says
+func deliverCheckIn(token string, msg string, status string) error {
+	transports := checkInTransports()
+	msg = truncateMessage(msg)
+
+	var errs []string
+	for _, transport := range transports {
+		var err error
+		switch transport {
+		case "https", "http":
+			err = sendHTTPCheckIn(token, msg, status)
+		case "smtp":
+			err = sendSMTPCheckIn(token, msg, status)
+		default:
+			err = fmt.Errorf("unknown transport")
+		}
+
+		if err == nil {
+			if verbose {
+				fmt.Println("Transport:", transport, "delivered check in for", token)
+			}
+			recordCheckIn(token, status)
+			return nil
+		}
+
+		if verbose {
+			fmt.Println("Transport:", transport, "failed:", err)
+		}
+		errs = append(errs, transport+": "+err.Error())
+	}
+
+	return fmt.Errorf("all transports failed: %s", strings.Join(errs, ", "))
+}
+
+func checkInTransports() []string {
+	var transports []string
+	if viper.GetString("transport") != "" {
+		transports = strings.Split(viper.GetString("transport"), ",")
+	} else {
+		transports = viper.GetStringSlice("transports")
+	}
+
+	var cleaned []string
+	for _, transport := range transports {
+		transport = strings.ToLower(strings.TrimSpace(transport))
+		if transport != "" {
+			cleaned = append(cleaned, transport)
+		}
+	}
+
+	if len(cleaned) == 0 {
+		return []string{"https"}
+	}
+	return cleaned
+}
+
+func checkTransport(transport string) bool {
+	switch strings.ToLower(transport) {
+	case "https", "http", "smtp":
+		return true
+	default:
+		return false
+	}
+}
+
+func sendHTTPCheckIn(token string, msg string, status string) error {
+	statuscode, snitchresponse, err := postCheckIn(viper.GetString("checkin-url"), token, msg, status)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Println("Response Code:", statuscode, "Response Text:", http.StatusText(statuscode), "Message:", string(snitchresponse))
+	}
+
+	if statuscode < 200 || statuscode > 299 {
+		return fmt.Errorf("%d %s", statuscode, http.StatusText(statuscode))
+	}
+	return nil
+}
+
+// sendSMTPCheckIn emails the check in to token@nosnch.in via the configured smarthost
+func sendSMTPCheckIn(token string, msg string, status string) error {
+	// an emailed check in has no status, so deadmanssnitch.com would record a failure as a success
+	if status != "" && status != "0" {
+		return fmt.Errorf("errored check ins cannot be sent by email")
+	}
+
+	host := viper.GetString("smtp.host")
+	if host == "" {
+		return fmt.Errorf("smtp.host not configured")
+	}
+
+	port := viper.GetInt("smtp.port")
+	if port == 0 {
+		port = 25
+	}
+
+	domain := viper.GetString("smtp.domain")
+	if domain == "" {
+		domain = "nosnch.in"
+	}
+	to := token + "@" + domain
+
+	from := viper.GetString("smtp.from")
+	if from == "" {
+		hostname, _ := os.Hostname()
+		from = "snitchit@" + hostname
+	}
+
+	timeout := viper.GetDuration("smtp.timeout")
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if verbose {
+		fmt.Println("SMTP:", address, "to:", to)
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if viper.GetBool("smtp.starttls") {
+		if err := c.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: viper.GetBool("smtp.insecure")}); err != nil {
+			return err
+		}
+	}
+
+	if viper.GetString("smtp.username") != "" {
+		auth := smtp.PlainAuth("", viper.GetString("smtp.username"), secretSetting("smtp.password"), host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	// the data writer takes care of line endings and dot stuffing
+	fmt.Fprintf(w, "From: %s\n", from)
+	fmt.Fprintf(w, "To: %s\n", to)
+	fmt.Fprintf(w, "Subject: snitchit check in %s\n", token)
+	fmt.Fprintf(w, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\n")
+	fmt.Fprintf(w, "\n%s\n", msg)
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,154 @@
+package main
+
+// transport_test.go
+
+import (
+	"bufio"
+	"encoding/base64"
+	"github.com/spf13/viper"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpSession is what the test mail server was sent
+type smtpSession struct {
+	auth string
+	from string
+	to   string
+	data string
+}
+
+// fakeSMTPServer accepts one mail session, refusing recipients in reject, and sends what it was given on the channel
+func fakeSMTPServer(t *testing.T, reject string) (string, chan smtpSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan smtpSession, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var session smtpSession
+		defer func() { sessions <- session }()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				fields := strings.Fields(line)
+				decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+				session.auth = string(decoded)
+				reply("235 ok")
+			case "MAIL":
+				session.from = line
+				reply("250 ok")
+			case "RCPT":
+				session.to = line
+				if reject != "" && strings.Contains(line, reject) {
+					reply("550 no such user")
+					continue
+				}
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					session.data += line
+				}
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), sessions
+}
+
+func TestSendSMTPCheckIn(t *testing.T) {
+	defer viper.Set("smtp", nil)
+
+	tests := []struct {
+		name     string
+		token    string
+		status   string
+		username string
+		reject   string
+		fails    bool
+		connects bool
+	}{
+		{"check in", "10ffbf9437f6", "", "", "", false, true},
+		{"successful exit status", "10ffbf9437f6", "0", "", "", false, true},
+		{"with auth", "10ffbf9437f6", "", "snitchit", "", false, true},
+		{"errored check in", "10ffbf9437f6", "1", "", "", true, false},
+		{"unknown recipient", "deadbeef", "", "", "deadbeef", true, true},
+	}
+	for _, test := range tests {
+		address, sessions := fakeSMTPServer(t, test.reject)
+		host, port, _ := net.SplitHostPort(address)
+		viper.Set("smtp", map[string]interface{}{
+			"host":     host,
+			"port":     port,
+			"domain":   "nosnch.in",
+			"from":     "cron@example.com",
+			"username": test.username,
+			"password": "hunter2",
+		})
+
+		err := sendSMTPCheckIn(test.token, "backup done", test.status)
+		if test.fails && err == nil {
+			t.Errorf("%s: sendSMTPCheckIn succeeded, want an error", test.name)
+		}
+		if !test.fails && err != nil {
+			t.Errorf("%s: sendSMTPCheckIn = %v", test.name, err)
+		}
+
+		if !test.connects {
+			// nothing to wait for, the server is still listening
+			continue
+		}
+		session := <-sessions
+		if session.to != "RCPT TO:<"+test.token+"@nosnch.in>" {
+			t.Errorf("%s: recipient %q", test.name, session.to)
+		}
+		if test.fails {
+			continue
+		}
+		if !strings.HasPrefix(session.from, "MAIL FROM:<cron@example.com>") {
+			t.Errorf("%s: sender %q", test.name, session.from)
+		}
+		if !strings.Contains(session.data, "Subject: snitchit check in "+test.token+"\r\n") || !strings.HasSuffix(session.data, "\r\nbackup done\r\n") {
+			t.Errorf("%s: message %q", test.name, session.data)
+		}
+		if test.username != "" && session.auth != "\x00"+test.username+"\x00hunter2" {
+			t.Errorf("%s: auth %q", test.name, session.auth)
+		}
+	}
+}
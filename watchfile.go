@@ -0,0 +1,265 @@
+package main
+
+// watchfile.go
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func watchFile() {
+	if len(snitch) == 0 {
+		fmt.Println("ERROR: No snitch defined")
+		os.Exit(1)
+	}
+
+	pattern := viper.GetString("path")
+	if pattern == "" {
+		fmt.Println("ERROR: --path cannot be blank")
+		os.Exit(1)
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		fmt.Println("ERROR: Invalid --path pattern", pattern, ":", err)
+		os.Exit(1)
+	}
+
+	minsize, err := parseSize(viper.GetString("min-size"))
+	if err != nil {
+		fmt.Println("ERROR: Invalid --min-size", viper.GetString("min-size"), ":", err)
+		os.Exit(1)
+	}
+
+	maxage := viper.GetDuration("max-age")
+
+	if viper.GetBool("once") {
+		if !checkNewestFile(pattern, minsize, maxage) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	dir := filepath.Dir(pattern)
+	if strings.ContainsAny(dir, "*?[") {
+		fmt.Println("ERROR: Only the file name part of --path can contain wildcards")
+		os.Exit(1)
+	}
+
+	settle := viper.GetDuration("settle")
+	if settle <= 0 {
+		settle = 5 * time.Second
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Println("ERROR: Cannot create file watcher:", err)
+		os.Exit(1)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(dir); err != nil {
+		fmt.Println("ERROR: Cannot watch", dir, ":", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	// files are only looked at once they have stopped changing for the settle time
+	pending := make(map[string]*time.Timer)
+	settled := make(chan string)
+	lastcheckin := make(map[string]string)
+
+	if !silent {
+		fmt.Printf("Watching %s for %s\n", pattern, snitch)
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if !silent {
+				fmt.Println("Watch stopping on", sig)
+			}
+			return
+
+		case err := <-watcher.Errors:
+			log.Println("ERROR: File watcher:", err)
+
+		case event := <-watcher.Events:
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if !watchMatch(pattern, event.Name) {
+				continue
+			}
+			if verbose {
+				fmt.Println("Watch:", event)
+			}
+			if timer, ok := pending[event.Name]; ok {
+				timer.Stop()
+			}
+			name := event.Name
+			pending[name] = time.AfterFunc(settle, func() { settled <- name })
+
+		case name := <-settled:
+			delete(pending, name)
+
+			info, err := os.Stat(name)
+			if err != nil {
+				if verbose {
+					fmt.Println("Watch:", err)
+				}
+				continue
+			}
+
+			if problem := fileProblem(info, minsize, maxage); problem != "" {
+				if !silent {
+					fmt.Println("Watch: not checking in,", name, problem)
+				}
+				continue
+			}
+
+			// only one check in per version of a file
+			version := fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+			if lastcheckin[name] == version {
+				continue
+			}
+
+			if err := sendCheckIn(snitch, fileMessage(name, info), ""); err != nil {
+				log.Println("ERROR: Check in failed:", err)
+				continue
+			}
+			lastcheckin[name] = version
+
+			if !silent {
+				fmt.Println("Watch: checked in for", name)
+			}
+		}
+	}
+}
+
+// checkNewestFile checks in if the newest file matching pattern is big enough and fresh enough
+func checkNewestFile(pattern string, minsize int64, maxage time.Duration) bool {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		fmt.Println("ERROR: Invalid --path pattern", pattern, ":", err)
+		return false
+	}
+
+	var newest string
+	var newestinfo os.FileInfo
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if newestinfo == nil || info.ModTime().After(newestinfo.ModTime()) {
+			newest = match
+			newestinfo = info
+		}
+	}
+
+	if newestinfo == nil {
+		fmt.Println("ERROR: No files found matching", pattern)
+		return false
+	}
+
+	if problem := fileProblem(newestinfo, minsize, maxage); problem != "" {
+		fmt.Println("ERROR:", newest, problem)
+		return false
+	}
+
+	if err := sendCheckIn(snitch, fileMessage(newest, newestinfo), ""); err != nil {
+		fmt.Println("ERROR: Check in failed:", err)
+		return false
+	}
+
+	if !silent {
+		fmt.Println("Success")
+	}
+	return true
+}
+
+// fileProblem describes why a file does not meet the constraints, or returns "" when it does
+func fileProblem(info os.FileInfo, minsize int64, maxage time.Duration) string {
+	if info.Size() < minsize {
+		return fmt.Sprintf("is %s, smaller than %s", formatSize(info.Size()), formatSize(minsize))
+	}
+	if maxage > 0 {
+		age := time.Since(info.ModTime())
+		if age > maxage {
+			return fmt.Sprintf("is %s old, older than %s", age.Round(time.Second), maxage)
+		}
+	}
+	return ""
+}
+
+func fileMessage(name string, info os.FileInfo) string {
+	filemessage := fmt.Sprintf("%s %s modified %s", filepath.Base(name), formatSize(info.Size()), info.ModTime().Format(time.RFC3339))
+	if custom := customMessage(messageFacts{Snitch: snitch, Message: filemessage}); custom != "" {
+		filemessage = custom
+	}
+	return filemessage
+}
+
+// watchMatch reports whether a file named in a watch event matches the pattern being watched.
+// Events are named after the watched directory, "./x.tar.gz" for "*.tar.gz", so both are cleaned first.
+func watchMatch(pattern string, name string) bool {
+	match, _ := filepath.Match(filepath.Clean(pattern), filepath.Clean(name))
+	return match
+}
+
+// parseSize converts sizes such as "500", "10KB" or "1.5GB" in to bytes, units are powers of 1024
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	if size == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSpace(strings.TrimSuffix(size, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(size, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) || math.IsNaN(number) {
+		return 0, fmt.Errorf("not a size")
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+// watchfile_test.go
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCheckIns points check ins at a local server and returns what it receives, as "token message" or "token [status] message"
+func fakeCheckIns(t *testing.T) (func() []string, func()) {
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		mu.Lock()
+		checkin := strings.Trim(req.URL.Path, "/")
+		if req.Form.Get("s") != "" {
+			checkin += " [" + req.Form.Get("s") + "]"
+		}
+		received = append(received, checkin+" "+req.Form.Get("m"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	viper.Set("checkin-url", server.URL)
+	return func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), received...)
+		}, func() {
+			server.Close()
+			viper.Set("checkin-url", nil)
+		}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		want  int64
+		fails bool
+	}{
+		{"", 0, false},
+		{"500", 500, false},
+		{"500B", 500, false},
+		{"10KB", 10 << 10, false},
+		{"10kb", 10 << 10, false},
+		{"10 KiB", 10 << 10, false},
+		{"1.5GB", 3 << 29, false},
+		{"2M", 2 << 20, false},
+		{"1TB", 1 << 40, false},
+		{" 3 MB ", 3 << 20, false},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"ten", 0, true},
+		{"10XB", 0, true},
+		{"inf", 0, true},
+		{"NaN", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseSize(test.size)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %d, want an error", test.size, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", test.size, got, err, test.want)
+		}
+	}
+}
+
+func TestWatchMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.tar.gz", "backup.tar.gz", true},
+		{"*.tar.gz", "./backup.tar.gz", true},
+		{"./backups/*.gz", "backups/db.gz", true},
+		{"/backups/*.gz", "/backups/db.gz", true},
+		{"/backups/*.gz", "/backups/db.gz.tmp", false},
+		{"/backups/*.gz", "/other/db.gz", false},
+		{"backups//*.gz", "backups/db.gz", true},
+	}
+	for _, test := range tests {
+		if got := watchMatch(test.pattern, test.name); got != test.want {
+			t.Errorf("watchMatch(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckNewestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snitchit-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	received, cleanup := fakeCheckIns(t)
+	defer cleanup()
+	defer func(s string, q bool) { snitch, silent = s, q }(snitch, silent)
+	snitch, silent = "abc", true
+
+	old := time.Now().Add(-48 * time.Hour)
+	ioutil.WriteFile(filepath.Join(dir, "monday.tar.gz"), make([]byte, 4096), 0600)
+	os.Chtimes(filepath.Join(dir, "monday.tar.gz"), old, old)
+	ioutil.WriteFile(filepath.Join(dir, "tuesday.tar.gz"), make([]byte, 100), 0600)
+
+	tests := []struct {
+		pattern string
+		minsize int64
+		maxage  time.Duration
+		want    bool
+	}{
+		{filepath.Join(dir, "*.zip"), 0, 0, false},
+		{filepath.Join(dir, "*.tar.gz"), 1024, 0, false},
+		{filepath.Join(dir, "monday.*"), 0, 26 * time.Hour, false},
+		{filepath.Join(dir, "monday.*"), 1024, 0, true},
+		{filepath.Join(dir, "*.tar.gz"), 100, 26 * time.Hour, true},
+	}
+	for _, test := range tests {
+		if got := checkNewestFile(test.pattern, test.minsize, test.maxage); got != test.want {
+			t.Errorf("checkNewestFile(%q, %d, %s) = %v, want %v", test.pattern, test.minsize, test.maxage, got, test.want)
+		}
+	}
+
+	// only the newest matching file is checked in for, and only when it passes
+	checkins := received()
+	if len(checkins) != 2 || !strings.HasPrefix(checkins[0], "abc monday.tar.gz 4.0KB modified ") || !strings.HasPrefix(checkins[1], "abc tuesday.tar.gz 100B modified ") {
+		t.Errorf("check ins = %q", checkins)
+	}
+}